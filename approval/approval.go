@@ -0,0 +1,54 @@
+package approval
+
+import (
+	"context"
+	"time"
+)
+
+// 统一后的审批单状态，各个审批来源需要把自己的状态转换成这几种
+const (
+	StatusPending  = "PENDING"
+	StatusApproved = "APPROVED"
+	StatusRejected = "REJECTED"
+	StatusCanceled = "CANCELED"
+)
+
+// User 审批单上的一个用户，不同来源使用的 ID 不同，飞书有 user_id 和 open_id，钉钉只有 userId
+type User struct {
+	UserID string
+	OpenID string
+}
+
+// Instance 一个审批单实例
+type Instance struct {
+	// Source 审批来源名称，例如 feishu、dingtalk
+	Source string
+	// ApprovalCode 审批定义 code，用于查找表单映射
+	ApprovalCode string
+	Code         string
+	Status       string
+
+	Applicant User
+	Approvers []User
+
+	// Form 解析后的表单
+	Form Form
+
+	// EndTime 审批结束时间，仍在审批中时为零值
+	EndTime time.Time
+}
+
+// Source 审批来源，负责列出审批单、获取审批单详情并解析表单
+type Source interface {
+	// Name 审批来源名称
+	Name() string
+	// ListInstances 列出 [start, end] 时间段内发起的审批单 code
+	ListInstances(ctx context.Context, start, end time.Time) ([]string, error)
+	// GetInstance 获取审批单详情，表单按控件类型解析为 Instance.Form
+	GetInstance(ctx context.Context, code string) (*Instance, error)
+}
+
+// UserNotifier 可以直接给审批单上的用户发消息的审批来源实现这个接口
+type UserNotifier interface {
+	NotifyUsers(ctx context.Context, instance *Instance, message, colors string) error
+}
@@ -0,0 +1,176 @@
+package approval
+
+import (
+	"strings"
+	"time"
+)
+
+// FieldType 统一后的表单控件类型，各个审批来源需要把自己的控件类型转换成这几种
+type FieldType string
+
+const (
+	FieldText         FieldType = "text"
+	FieldNumber       FieldType = "number"
+	FieldSelect       FieldType = "select"
+	FieldMultiSelect  FieldType = "multiSelect"
+	FieldDate         FieldType = "date"
+	FieldDateInterval FieldType = "dateInterval"
+	FieldTable        FieldType = "table"
+	FieldContact      FieldType = "contact"
+	// FieldUnknown 暂不支持的控件，只保留原始文本
+	FieldUnknown FieldType = "unknown"
+)
+
+// Field 一个表单控件
+type Field struct {
+	ID   string
+	Name string
+	Type FieldType
+
+	// Value 单行文本、多行文本、数字、单选等单值控件的值
+	Value string
+	// Values 多选、联系人等多值控件的值
+	Values []string
+	// Start 日期控件的值，日期区间控件的开始时间
+	Start time.Time
+	// End 日期区间控件的结束时间
+	End time.Time
+	// Rows 明细(表格)控件，每一行是一组子控件
+	Rows []Form
+}
+
+// String 把控件值渲染为文本；明细控件每一行的单元格用空格拼接、行之间换行，
+// 与 "服务名 空格 版本号" 的格式保持一致
+func (f Field) String() string {
+	switch f.Type {
+	case FieldMultiSelect, FieldContact:
+		return strings.Join(f.Values, ",")
+	case FieldDate:
+		if f.Start.IsZero() {
+			return f.Value
+		}
+		return f.Start.Format("2006-01-02 15:04")
+	case FieldDateInterval:
+		if f.Start.IsZero() {
+			return f.Value
+		}
+		return f.Start.Format("2006-01-02 15:04") + " ~ " + f.End.Format("2006-01-02 15:04")
+	case FieldTable:
+		var rows []string
+		for _, row := range f.Rows {
+			var cells []string
+			for _, cell := range row {
+				if v := strings.TrimSpace(cell.String()); v != "" {
+					cells = append(cells, v)
+				}
+			}
+			rows = append(rows, strings.Join(cells, " "))
+		}
+		return strings.Join(rows, "\n")
+	default:
+		return f.Value
+	}
+}
+
+// Form 审批单表单，按控件在表单中的顺序排列
+type Form []Field
+
+// Get 按控件名称查找控件
+func (f Form) Get(name string) (Field, bool) {
+	for _, field := range f {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return Field{}, false
+}
+
+// Text 按控件名称取出控件值的文本，控件不存在时返回空字符串
+func (f Form) Text(name string) string {
+	field, ok := f.Get(name)
+	if !ok {
+		return ""
+	}
+	return field.String()
+}
+
+// FormSchema 声明发版需要的信息分别来自表单中的哪个控件，控件名称为空表示表单中没有这一项
+type FormSchema struct {
+	// Manifest 多行文本控件，每行为 "服务名 空格 版本号"
+	Manifest string
+	// Table 明细控件，每行一个服务，列名由 Columns 声明
+	Table   string
+	Columns TableColumns
+	// Service/Version 单个服务发版时分别填写服务名和版本号的控件
+	Service string
+	Version string
+	// Environment 发版环境控件，一般为单选
+	Environment string
+	// DeployAt 计划发版时间，日期控件
+	DeployAt string
+}
+
+// DefaultFormSchema 未单独声明的审批定义使用的表单映射
+var DefaultFormSchema = FormSchema{
+	Manifest: "JobNameAndVersionNumber",
+}
+
+// FormSchemas 审批定义 code(飞书 approval_code、钉钉 processCode、企业微信 template_id) -> 表单映射
+var FormSchemas = map[string]FormSchema{
+	// "xxxxxxxxxxxxxxx": {Service: "服务名", Version: "版本号", Environment: "环境", DeployAt: "发版时间"},
+	// "yyyyyyyyyyyyyyy": {Table: "发版清单"},
+}
+
+// SchemaFor 返回审批定义对应的表单映射
+func SchemaFor(approvalCode string) FormSchema {
+	if schema, ok := FormSchemas[approvalCode]; ok {
+		return schema
+	}
+	return DefaultFormSchema
+}
+
+// ReleaseFields 按表单映射从表单中取出的发版信息
+type ReleaseFields struct {
+	// Table 明细控件中的发版项，尚未校验
+	Table       []ReleaseItem
+	Manifest    string
+	Service     string
+	Version     string
+	Environment string
+	DeployAt    time.Time
+}
+
+// Extract 按表单映射从表单中取出发版信息
+func (s FormSchema) Extract(form Form) ReleaseFields {
+	fields := ReleaseFields{
+		Manifest:    form.Text(s.Manifest),
+		Service:     strings.TrimSpace(form.Text(s.Service)),
+		Version:     strings.TrimSpace(form.Text(s.Version)),
+		Environment: strings.TrimSpace(form.Text(s.Environment)),
+	}
+	if deployAt, ok := form.Get(s.DeployAt); ok && s.DeployAt != "" {
+		fields.DeployAt = deployAt.Start
+	}
+	if table, ok := form.Get(s.Table); ok && s.Table != "" && table.Type == FieldTable {
+		columns := s.Columns
+		if columns == (TableColumns{}) {
+			columns = DefaultTableColumns
+		}
+		fields.Table = tableItems(table, columns)
+	}
+	return fields
+}
+
+// Lines 把发版信息展开为 "服务名 版本号" 的行，单独填写的服务和版本排在最前面
+func (r ReleaseFields) Lines() []string {
+	var lines []string
+	if r.Service != "" || r.Version != "" {
+		lines = append(lines, strings.TrimSpace(r.Service+" "+r.Version))
+	}
+	for _, line := range strings.Split(r.Manifest, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
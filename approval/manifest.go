@@ -0,0 +1,145 @@
+package approval
+
+import (
+	"fmt"
+	"strings"
+	"testapi/registry"
+)
+
+// 发版策略
+const (
+	StrategyRolling = registry.StrategyRolling
+	StrategyGray    = registry.StrategyGray
+)
+
+// TableColumns 明细控件中各列的名称
+type TableColumns struct {
+	Service   string
+	Version   string
+	Cluster   string
+	Namespace string
+	Strategy  string
+}
+
+// DefaultTableColumns 未单独声明时明细控件使用的列名
+var DefaultTableColumns = TableColumns{
+	Service:   "服务",
+	Version:   "版本",
+	Cluster:   "集群",
+	Namespace: "命名空间",
+	Strategy:  "发布策略",
+}
+
+// ReleaseItem 发版清单中的一项，对应明细控件的一行或多行文本中的一行
+type ReleaseItem struct {
+	// Row 在清单中的行号，从 1 开始
+	Row       int
+	Service   string
+	Version   string
+	Cluster   string
+	Namespace string
+	Strategy  string
+}
+
+// RowError 发版清单中某一行的校验错误
+type RowError struct {
+	// Row 行号，为 0 表示不属于某一行的表单错误，例如发版环境
+	Row int
+	// Raw 这一行的原始内容，便于申请人定位
+	Raw string
+	Err string
+}
+
+func (e RowError) Error() string {
+	if e.Row == 0 {
+		return fmt.Sprintf("[%s]: %s", e.Raw, e.Err)
+	}
+	return fmt.Sprintf("第 %d 行 [%s]: %s", e.Row, e.Raw, e.Err)
+}
+
+// Items 把发版信息解析为发版清单；明细控件优先，其次是单独填写的服务和版本，最后是多行文本
+func (r ReleaseFields) Items() ([]ReleaseItem, []RowError) {
+	var items []ReleaseItem
+	var errs []RowError
+	add := func(item ReleaseItem, raw string) {
+		item.Row = len(items) + len(errs) + 1
+		if err := item.validate(); err != "" {
+			errs = append(errs, RowError{Row: item.Row, Raw: raw, Err: err})
+			return
+		}
+		items = append(items, item)
+	}
+
+	for _, row := range r.Table {
+		add(row, strings.Join(nonEmpty(row.Service, row.Version, row.Cluster, row.Namespace, row.Strategy), " "))
+	}
+
+	for _, line := range r.Lines() {
+		// 使用strings.Fields处理一个或多个空格作为分隔符
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			errs = append(errs, RowError{Row: len(items) + len(errs) + 1, Raw: line, Err: "格式应为 \"服务名 版本号\""})
+			continue
+		}
+		add(ReleaseItem{Service: parts[0], Version: parts[1]}, line)
+	}
+	return items, errs
+}
+
+// validate 校验单行内容，返回错误描述，没有错误时返回空字符串
+func (item *ReleaseItem) validate() string {
+	switch {
+	case item.Service == "" && item.Version == "":
+		return "服务和版本不能为空"
+	case item.Service == "":
+		return "服务不能为空"
+	case item.Version == "":
+		return "版本不能为空"
+	case strings.ContainsAny(item.Service, " \t"):
+		return "服务名不能包含空格"
+	case strings.ContainsAny(item.Version, " \t"):
+		return "版本号不能包含空格"
+	}
+
+	// 策略为空时由 Validate 按服务登记的默认策略补全
+	switch strings.ToLower(item.Strategy) {
+	case "":
+	case StrategyRolling:
+		item.Strategy = StrategyRolling
+	case StrategyGray:
+		item.Strategy = StrategyGray
+	default:
+		return fmt.Sprintf("不支持的发布策略 %s，可选 %s、%s", item.Strategy, StrategyRolling, StrategyGray)
+	}
+	return ""
+}
+
+// tableItems 把明细控件的每一行按列名转换为发版项，空行忽略
+func tableItems(field Field, columns TableColumns) []ReleaseItem {
+	var items []ReleaseItem
+	for _, row := range field.Rows {
+		item := ReleaseItem{
+			Service:   strings.TrimSpace(row.Text(columns.Service)),
+			Version:   strings.TrimSpace(row.Text(columns.Version)),
+			Cluster:   strings.TrimSpace(row.Text(columns.Cluster)),
+			Namespace: strings.TrimSpace(row.Text(columns.Namespace)),
+			Strategy:  strings.TrimSpace(row.Text(columns.Strategy)),
+		}
+		if item == (ReleaseItem{}) {
+			continue
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// nonEmpty 过滤掉空字符串
+func nonEmpty(values ...string) []string {
+	var result []string
+	for _, v := range values {
+		if v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
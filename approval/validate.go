@@ -0,0 +1,84 @@
+package approval
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testapi/k8s"
+	"testapi/registry"
+)
+
+// AllowedEnvironments 表单中环境控件允许的取值，为空时不校验；接口发版要求配置
+var AllowedEnvironments = []string{
+	// "test", "prod",
+}
+
+// SetAllowedEnvironments 设置允许的发版环境，空字符串忽略，启动时调用
+func SetAllowedEnvironments(environments []string) {
+	allowed := make([]string, 0, len(environments))
+	for _, environment := range environments {
+		if environment = strings.TrimSpace(environment); environment != "" {
+			allowed = append(allowed, environment)
+		}
+	}
+	AllowedEnvironments = allowed
+}
+
+// Validate 解析并校验整个发版清单，返回所有错误；只要有错误，整个清单都不应该执行
+func (r ReleaseFields) Validate() ([]ReleaseItem, []RowError) {
+	items, errs := r.Items()
+	if len(items) == 0 && len(errs) == 0 {
+		errs = append(errs, RowError{Err: "发版清单为空"})
+	}
+
+	if r.Environment != "" && len(AllowedEnvironments) > 0 && !contains(AllowedEnvironments, r.Environment) {
+		errs = append(errs, RowError{Raw: r.Environment, Err: fmt.Sprintf("不允许的发版环境，可选 %s", strings.Join(AllowedEnvironments, "、"))})
+	}
+
+	// 同一个集群和命名空间中的同一个服务只能出现一次
+	seen := make(map[string]int)
+	for i := range items {
+		item := &items[i]
+		raw := item.Service + " " + item.Version
+		service, ok := registry.Lookup(item.Service)
+		if !ok && registry.Configured() {
+			errs = append(errs, RowError{Row: item.Row, Raw: raw, Err: "未登记的服务"})
+			continue
+		}
+		if !ok {
+			service = registry.Resolve(item.Service)
+		}
+
+		if item.Strategy == "" {
+			item.Strategy = service.Rollout.Strategy
+		}
+		if item.Strategy == "" {
+			item.Strategy = StrategyRolling
+		}
+
+		// 服务登记时已经校验过正则
+		if !regexp.MustCompile(service.TagPattern).MatchString(item.Version) {
+			errs = append(errs, RowError{Row: item.Row, Raw: raw, Err: fmt.Sprintf("版本号不符合规则 %s", service.TagPattern)})
+		}
+
+		// 按实际发版的集群和命名空间比较，不填和填写默认值的是同一个 Deployment
+		target := k8s.Target{Cluster: item.Cluster, Namespace: item.Namespace}.ForJob(item.Service)
+		key := item.Service + "@" + target.Cluster + "/" + target.Namespace
+		if first, ok := seen[key]; ok {
+			errs = append(errs, RowError{Row: item.Row, Raw: raw, Err: fmt.Sprintf("与第 %d 行重复", first)})
+			continue
+		}
+		seen[key] = item.Row
+	}
+	return items, errs
+}
+
+// contains 判断切片中是否包含 value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testapi/auth"
+	"testapi/k8s"
+	"testapi/state"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseFilter 从查询参数读取条件：service、user、from、to；时间为 2006-01-02 或 RFC3339，
+// 只有日期时 to 包含当天
+func parseFilter(c *gin.Context) (Filter, error) {
+	f := Filter{Service: c.Query("service"), User: c.Query("user")}
+	for name, target := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		v := c.Query(name)
+		if v == "" {
+			continue
+		}
+		if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+			if name == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+			*target = t
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return Filter{}, fmt.Errorf("%s 格式应为 2006-01-02 或 RFC3339: %s", name, v)
+		}
+		*target = t
+	}
+	return f, nil
+}
+
+// visibleEntries 只保留调用方对服务和发版环境有 viewer 权限的记录；
+// 早期的记录没有保存环境，按审批单的发版状态补全，读不到时环境未知
+func visibleEntries(c *gin.Context, entries []Entry) []Entry {
+	p := auth.Current(c)
+	environments := make(map[string]string)
+	visible := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		environment := e.Environment
+		if environment == "" {
+			var ok bool
+			if environment, ok = environments[e.InstanceCode]; !ok {
+				if rel, err := state.Get(e.InstanceCode); err == nil && rel != nil {
+					environment = rel.CurrentEnvironment()
+				}
+				environments[e.InstanceCode] = environment
+			}
+		}
+		if p.Can(auth.RoleViewer, strings.TrimSuffix(e.Service, k8s.GrayLevelSuffix), environment) {
+			visible = append(visible, e)
+		}
+	}
+	return visible
+}
+
+// ListHandler 按条件查询审计记录，只返回调用方有 viewer 权限的记录，见 visibleEntries
+func ListHandler(c *gin.Context) {
+	f, err := parseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	entries, err := Query(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	entries = visibleEntries(c, entries)
+	c.JSON(http.StatusOK, gin.H{"records": entries})
+}
+
+// ExportHandler 按条件导出审计记录为文件，format 为 csv(默认) 或 json；与 ListHandler 一样按权限过滤
+func ExportHandler(c *gin.Context) {
+	f, err := parseFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format 应为 csv 或 json"})
+		return
+	}
+	entries, err := Query(f)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	entries = visibleEntries(c, entries)
+
+	filename := fmt.Sprintf("release-audit-%s.%s", time.Now().Format("20060102150405"), format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == "json" {
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := WriteCSV(c.Writer, entries); err != nil {
+		c.Error(err)
+	}
+}
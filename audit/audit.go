@@ -0,0 +1,220 @@
+package audit
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testapi/k8s"
+	"testapi/metrics"
+	"testapi/state"
+	"testapi/store"
+	"time"
+)
+
+// 审计记录保存在存储的这个集合中，ID -> Entry 的 JSON；记录只写入一次，没有修改和删除的接口
+const auditKey = "release:audit"
+
+// 修改 Deployment 的操作
+const (
+	ActionDeploy   = "deploy"
+	ActionRetry    = "retry"
+	ActionRollback = "rollback"
+	ActionPromote  = "promote"
+)
+
+// 操作结果
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+// Entry 一条审计记录，对应一次对某个集群中某个服务的发版、重试、回滚或转正
+type Entry struct {
+	ID           string   `json:"id"`
+	InstanceCode string   `json:"instanceCode"`
+	Source       string   `json:"source,omitempty"`
+	ApprovalCode string   `json:"approvalCode,omitempty"`
+	Applicant    string   `json:"applicant,omitempty"`
+	Approvers    []string `json:"approvers,omitempty"`
+	// Operator 点击卡片按钮或者通过接口重新发版的用户，审批单自动发版时为空
+	Operator  string `json:"operator,omitempty"`
+	Action    string `json:"action"`
+	Service   string `json:"service"`
+	Version   string `json:"version,omitempty"`
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	// Environment 审批单的发版环境，查询时按服务和环境检查 viewer 权限
+	Environment string    `json:"environment,omitempty"`
+	FromImage   string    `json:"fromImage,omitempty"`
+	ToImage     string    `json:"toImage,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	EndedAt     time.Time `json:"endedAt"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Operation 一次修改 Deployment 的操作及结果
+type Operation struct {
+	InstanceCode string
+	Action       string
+	Operator     string
+	JobName      string
+	Version      string
+	Target       k8s.Target
+	Change       k8s.ImageChange
+	StartedAt    time.Time
+	Err          error
+}
+
+var seq atomic.Int64
+
+// maxCreateAttempts 审计记录 ID 冲突时最多尝试写入的次数
+const maxCreateAttempts = 3
+
+// Record 把操作写成审计记录并计入发版指标，申请人和审批人从审批单的发版状态中读取；
+// 记录以只创建的方式写入，不会覆盖已有的记录，保存失败时只记录日志
+func Record(op Operation) {
+	now := time.Now()
+	target := op.Target.ForJob(op.JobName)
+	entry := Entry{
+		ID:           fmt.Sprintf("%s-%s", strconv.FormatInt(now.UnixNano(), 36), strconv.FormatInt(seq.Add(1), 36)),
+		InstanceCode: op.InstanceCode,
+		Operator:     op.Operator,
+		Action:       op.Action,
+		Service:      op.JobName,
+		Version:      op.Version,
+		Cluster:      target.Cluster,
+		Namespace:    target.Namespace,
+		FromImage:    k8s.FormatImages(op.Change.From),
+		ToImage:      k8s.FormatImages(op.Change.To),
+		StartedAt:    op.StartedAt,
+		EndedAt:      now,
+		Result:       ResultSucceeded,
+	}
+	if op.Err != nil {
+		entry.Result = ResultFailed
+		entry.Error = op.Err.Error()
+	}
+	metrics.ObserveRelease(entry.Service, entry.Action, entry.Result, entry.EndedAt.Sub(entry.StartedAt))
+	if rel, err := state.Get(op.InstanceCode); err == nil && rel != nil {
+		entry.Source = rel.Source
+		entry.ApprovalCode, entry.Applicant, entry.Approvers = rel.Requester()
+		entry.Environment = rel.CurrentEnvironment()
+	}
+
+	// 只创建不覆盖，ID 冲突(例如多个副本在同一纳秒写入)时换一个 ID 重新写入，已有的记录不会被改写
+	for attempt := 0; attempt < maxCreateAttempts; attempt++ {
+		if attempt > 0 {
+			entry.ID = fmt.Sprintf("%s-%s", strconv.FormatInt(time.Now().UnixNano(), 36), strconv.FormatInt(seq.Add(1), 36))
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("序列化审计记录失败: %v", err)
+			return
+		}
+		created, err := store.Default().Create(context.TODO(), auditKey, entry.ID, string(data))
+		if err != nil {
+			log.Printf("保存审计记录失败: %v, 记录: %s", err, data)
+			return
+		}
+		if created {
+			return
+		}
+	}
+	log.Printf("保存审计记录失败: ID 连续 %d 次冲突, 审批单 %s %s %s", maxCreateAttempts, entry.InstanceCode, entry.Action, entry.Service)
+}
+
+// Filter 查询条件，为空的条件不限制
+type Filter struct {
+	// Service 服务名，同时匹配灰度服务
+	Service string
+	// User 申请人、审批人或操作人
+	User string
+	// From/To 按操作开始时间筛选，[From, To)
+	From time.Time
+	To   time.Time
+}
+
+func (f Filter) matches(e Entry) bool {
+	if f.Service != "" && e.Service != f.Service && e.Service != f.Service+k8s.GrayLevelSuffix {
+		return false
+	}
+	if f.User != "" && e.Applicant != f.User && e.Operator != f.User && !contains(e.Approvers, f.User) {
+		return false
+	}
+	if !f.From.IsZero() && e.StartedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.StartedAt.Before(f.To) {
+		return false
+	}
+	return true
+}
+
+// Query 按条件查询审计记录，按操作开始时间从早到晚排列
+func Query(f Filter) ([]Entry, error) {
+	entries, err := store.Default().List(context.TODO(), auditKey)
+	if err != nil {
+		return nil, err
+	}
+	result := []Entry{}
+	for id, data := range entries {
+		var e Entry
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			log.Printf("解析审计记录 %s 失败: %v", id, err)
+			continue
+		}
+		if f.matches(e) {
+			result = append(result, e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].StartedAt.Equal(result[j].StartedAt) {
+			return result[i].ID < result[j].ID
+		}
+		return result[i].StartedAt.Before(result[j].StartedAt)
+	})
+	return result, nil
+}
+
+// csvHeader 导出 CSV 的列
+var csvHeader = []string{
+	"id", "instanceCode", "source", "approvalCode", "applicant", "approvers", "operator", "action",
+	"service", "version", "cluster", "namespace", "environment", "fromImage", "toImage", "startedAt", "endedAt", "result", "error",
+}
+
+// WriteCSV 把审计记录写成 CSV，审批人用分号分隔，时间为 RFC3339
+func WriteCSV(w io.Writer, entries []Entry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		record := []string{
+			e.ID, e.InstanceCode, e.Source, e.ApprovalCode, e.Applicant, strings.Join(e.Approvers, ";"), e.Operator, e.Action,
+			e.Service, e.Version, e.Cluster, e.Namespace, e.Environment, e.FromImage, e.ToImage,
+			e.StartedAt.Format(time.RFC3339), e.EndedAt.Format(time.RFC3339), e.Result, e.Error,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"sigs.k8s.io/yaml"
+)
+
+// 角色，后面的角色包含前面角色的权限：viewer 只读，releaser 可以发版和处理自己范围内的发版，admin 可以管理冻结等全局配置
+const (
+	RoleViewer   = "viewer"
+	RoleReleaser = "releaser"
+	RoleAdmin    = "admin"
+)
+
+// roleLevel 角色的权限等级，未知角色为 0
+func roleLevel(role string) int {
+	switch role {
+	case RoleViewer:
+		return 1
+	case RoleReleaser:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Grant 角色及生效范围；服务支持 path.Match 通配符，服务和环境为空表示不限制
+type Grant struct {
+	Role         string   `json:"role"`
+	Services     []string `json:"services,omitempty"`
+	Environments []string `json:"environments,omitempty"`
+}
+
+// allows 是否允许以 role 操作服务和环境；服务或环境未知(为空)时只有不限制对应范围的角色允许
+func (g Grant) allows(role, service, environment string) bool {
+	if !g.hasRole(role, service) || service == "" && len(g.Services) > 0 {
+		return false
+	}
+	if len(g.Environments) == 0 {
+		return true
+	}
+	return environment != "" && contains(g.Environments, environment)
+}
+
+// hasRole 是否拥有 role 且服务在范围内，不检查环境；service 为空时不检查服务范围，只用于接口入口的角色检查
+func (g Grant) hasRole(role, service string) bool {
+	if roleLevel(g.Role) < roleLevel(role) {
+		return false
+	}
+	if service == "" || len(g.Services) == 0 {
+		return true
+	}
+	for _, pattern := range g.Services {
+		if ok, _ := path.Match(pattern, service); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (g Grant) validate(name string) error {
+	if roleLevel(g.Role) == 0 {
+		return fmt.Errorf("%s 的角色 %s 无效，可选 %s、%s、%s", name, g.Role, RoleViewer, RoleReleaser, RoleAdmin)
+	}
+	for _, pattern := range g.Services {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%s 的服务 %s 无效: %v", name, pattern, err)
+		}
+	}
+	return nil
+}
+
+// Token 静态 API token，一般给 CI 和运维脚本使用
+type Token struct {
+	// Name 调用方名称，记录为发版的操作人
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Grant
+}
+
+// Binding 把 OIDC 用户、OIDC 组或飞书用户映射到角色
+type Binding struct {
+	// Subjects user:<OIDC 用户>、group:<OIDC 组>、feishu:<飞书 user_id 或 open_id>
+	Subjects []string `json:"subjects"`
+	Grant
+}
+
+// Config 认证配置文件格式，YAML 或 JSON
+type Config struct {
+	Tokens   []Token   `json:"tokens,omitempty"`
+	OIDC     *OIDC     `json:"oidc,omitempty"`
+	Bindings []Binding `json:"bindings,omitempty"`
+}
+
+var (
+	mu     sync.RWMutex
+	config Config
+)
+
+// Parse 解析认证配置
+func Parse(data []byte) (Config, error) {
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return Config{}, fmt.Errorf("解析认证配置失败: %w", err)
+	}
+	for i, t := range c.Tokens {
+		if t.Name == "" || t.Token == "" {
+			return Config{}, fmt.Errorf("第 %d 个 token 没有名称或 token", i+1)
+		}
+		if err := t.validate("token " + t.Name); err != nil {
+			return Config{}, err
+		}
+	}
+	for i, b := range c.Bindings {
+		if len(b.Subjects) == 0 {
+			return Config{}, fmt.Errorf("第 %d 个角色绑定没有 subjects", i+1)
+		}
+		if err := b.validate(fmt.Sprintf("第 %d 个角色绑定", i+1)); err != nil {
+			return Config{}, err
+		}
+	}
+	if c.OIDC != nil && c.OIDC.Issuer == "" {
+		return Config{}, fmt.Errorf("oidc 没有配置 issuer")
+	}
+	return c, nil
+}
+
+// Set 替换当前的认证配置
+func Set(c Config) {
+	if c.OIDC != nil {
+		c.OIDC = c.OIDC.withDefaults()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+}
+
+// Load 从文件加载认证配置
+func Load(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("读取认证配置失败: %w", err)
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	Set(c)
+	return nil
+}
+
+// AddTokens 追加拥有 admin 角色的 API token，用于 API_TOKENS 环境变量，空字符串忽略
+func AddTokens(list []string) {
+	mu.Lock()
+	defer mu.Unlock()
+	for i, token := range list {
+		if token = strings.TrimSpace(token); token != "" {
+			config.Tokens = append(config.Tokens, Token{
+				Name:  fmt.Sprintf("api-token-%d", i+1),
+				Token: token,
+				Grant: Grant{Role: RoleAdmin},
+			})
+		}
+	}
+}
+
+// Principal 通过认证的调用方
+type Principal struct {
+	// Name token 名称、OIDC 用户或飞书用户
+	Name   string
+	Grants []Grant
+}
+
+// Can 是否允许以 role 操作服务和环境；服务或环境未知(为空)时，限制了对应范围的角色不允许
+func (p *Principal) Can(role, service, environment string) bool {
+	if p == nil {
+		return false
+	}
+	for _, g := range p.Grants {
+		if g.allows(role, service, environment) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAll 是否允许以 role 操作环境中的每个服务，用于涉及多个服务的记录；services 为空时按服务未知检查
+func (p *Principal) CanAll(role string, services []string, environment string) bool {
+	if len(services) == 0 {
+		return p.Can(role, "", environment)
+	}
+	for _, service := range services {
+		if !p.Can(role, service, environment) {
+			return false
+		}
+	}
+	return true
+}
+
+// HasRole 是否拥有 role，service 不为空时同时要求服务在范围内；不检查环境，
+// 操作具体发版的接口还需要用 Allowed 按发版的服务和环境检查
+func (p *Principal) HasRole(role, service string) bool {
+	if p == nil {
+		return false
+	}
+	for _, g := range p.Grants {
+		if g.hasRole(role, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantsFor 按角色绑定找出 subjects 对应的所有角色
+func grantsFor(c Config, subjects []string) []Grant {
+	var grants []Grant
+	for _, b := range c.Bindings {
+		for _, subject := range subjects {
+			if contains(b.Subjects, subject) {
+				grants = append(grants, b.Grant)
+				break
+			}
+		}
+	}
+	return grants
+}
+
+// FeishuUser 飞书用户的身份，角色来自 subjects 为 feishu:<user_id> 或 feishu:<open_id> 的角色绑定
+func FeishuUser(userID, openID string) *Principal {
+	mu.RLock()
+	defer mu.RUnlock()
+	var subjects []string
+	name := openID
+	if userID != "" {
+		subjects = append(subjects, "feishu:"+userID)
+		name = userID
+	}
+	if openID != "" {
+		subjects = append(subjects, "feishu:"+openID)
+	}
+	return &Principal{Name: name, Grants: grantsFor(config, subjects)}
+}
+
+// authenticate 按 API token、OIDC JWT 的顺序校验 bearer token
+func authenticate(bearer string) (*Principal, error) {
+	mu.RLock()
+	c := config
+	mu.RUnlock()
+
+	for _, t := range c.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t.Token), []byte(bearer)) == 1 {
+			return &Principal{Name: t.Name, Grants: []Grant{t.Grant}}, nil
+		}
+	}
+	if c.OIDC == nil || strings.Count(bearer, ".") != 2 {
+		return nil, fmt.Errorf("无效的 API token")
+	}
+	user, groups, err := c.OIDC.verify(bearer)
+	if err != nil {
+		return nil, err
+	}
+	subjects := []string{"user:" + user}
+	for _, group := range groups {
+		subjects = append(subjects, "group:"+group)
+	}
+	return &Principal{Name: user, Grants: grantsFor(c, subjects)}, nil
+}
+
+// 通过认证的调用方保存在 gin.Context 的这个 key 中
+const principalKey = "auth.principal"
+
+// Authenticate 要求请求带 "Authorization: Bearer <API token 或 OIDC JWT>"，通过后把调用方保存到上下文
+func Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || strings.TrimSpace(bearer) == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "缺少 Authorization: Bearer 请求头"})
+			return
+		}
+		p, err := authenticate(strings.TrimSpace(bearer))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.Set(principalKey, p)
+		c.Next()
+	}
+}
+
+// Require 要求调用方拥有 role，路径中有 :service 参数时同时检查服务范围；只是接口入口的角色检查，
+// 请求的服务和环境要在 handler 中查出后再用 Allowed 检查，列表接口用 Principal.Can 按每条记录过滤
+func Require(role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		service := c.Param("service")
+		if p := Current(c); !p.HasRole(role, service) {
+			deny(c, p, role, service, "")
+			return
+		}
+		c.Next()
+	}
+}
+
+// Current 返回通过认证的调用方，没有经过 Authenticate 时返回 nil
+func Current(c *gin.Context) *Principal {
+	v, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	p, _ := v.(*Principal)
+	return p
+}
+
+// Allowed 检查调用方是否可以以 role 操作服务和环境，不允许时返回 403 并中止请求
+func Allowed(c *gin.Context, role, service, environment string) bool {
+	p := Current(c)
+	if p.Can(role, service, environment) {
+		return true
+	}
+	deny(c, p, role, service, environment)
+	return false
+}
+
+// AllowedAll 检查调用方是否可以以 role 操作环境中的每个服务，services 为空时按服务未知检查；不允许时返回 403 并中止请求
+func AllowedAll(c *gin.Context, role string, services []string, environment string) bool {
+	if len(services) == 0 {
+		return Allowed(c, role, "", environment)
+	}
+	for _, service := range services {
+		if !Allowed(c, role, service, environment) {
+			return false
+		}
+	}
+	return true
+}
+
+// deny 返回 403 并中止请求
+func deny(c *gin.Context, p *Principal, role, service, environment string) {
+	target := strings.Trim(service+" "+environment, " ")
+	if target == "" {
+		target = "这个接口"
+	}
+	name := ""
+	if p != nil {
+		name = p.Name
+	}
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("%s 没有 %s 的 %s 权限", name, target, role)})
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
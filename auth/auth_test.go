@@ -0,0 +1,254 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testIdP 本地生成 RSA 和 EC 密钥的 OIDC 身份提供方，通过 httptest 提供发现文档和 JWKS；
+// JWKS 中还有一个不支持的 Ed25519 公钥，校验时应当跳过
+type testIdP struct {
+	server *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newTestIdP(t *testing.T) *testIdP {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &testIdP{rsaKey: rsaKey, ecKey: ecKey}
+
+	encode := func(i *big.Int, size int) string {
+		return base64.RawURLEncoding.EncodeToString(i.FillBytes(make([]byte, size)))
+	}
+	jwks := map[string]interface{}{"keys": []jwk{
+		{Kid: "rsa", Kty: "RSA", Use: "sig", N: encode(rsaKey.N, rsaKey.Size()), E: encode(big.NewInt(int64(rsaKey.E)), 3)},
+		{Kid: "ec", Kty: "EC", Use: "sig", Crv: "P-256", X: encode(ecKey.X, 32), Y: encode(ecKey.Y, 32)},
+		{Kid: "ed", Kty: "OKP", Use: "sig", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(make([]byte, 32))},
+	}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"issuer": idp.server.URL, "jwks_uri": idp.server.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jwks)
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// sign 用 kid 对应的私钥签发 JWT，claims 覆盖默认的 iss、aud、sub 和有效期，值为 nil 时去掉这个 claim
+func (idp *testIdP) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	all := jwt.MapClaims{
+		"iss": idp.server.URL,
+		"aud": "release-bot",
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		if v == nil {
+			delete(all, k)
+			continue
+		}
+		all[k] = v
+	}
+	var token *jwt.Token
+	var key interface{}
+	switch kid {
+	case "rsa":
+		token, key = jwt.NewWithClaims(jwt.SigningMethodRS256, all), idp.rsaKey
+	case "ec":
+		token, key = jwt.NewWithClaims(jwt.SigningMethodES256, all), idp.ecKey
+	default:
+		t.Fatalf("未知的 kid %s", kid)
+	}
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// setConfig 替换认证配置，测试结束后恢复
+func setConfig(t *testing.T, c Config) {
+	t.Helper()
+	mu.RLock()
+	previous := config
+	mu.RUnlock()
+	Set(c)
+	t.Cleanup(func() { Set(previous) })
+}
+
+func TestAuthenticate(t *testing.T) {
+	idp := newTestIdP(t)
+	setConfig(t, Config{
+		Tokens: []Token{{Name: "ci", Token: "static-token", Grant: Grant{Role: RoleReleaser, Services: []string{"order-*"}}}},
+		OIDC:   &OIDC{Issuer: idp.server.URL, Audience: "release-bot"},
+		Bindings: []Binding{
+			{Subjects: []string{"user:alice"}, Grant: Grant{Role: RoleViewer}},
+			{Subjects: []string{"group:sre"}, Grant: Grant{Role: RoleAdmin}},
+		},
+	})
+
+	tests := []struct {
+		name    string
+		bearer  string
+		want    string
+		wantErr bool
+		// role 调用方应当拥有的角色，为空时不检查
+		role string
+	}{
+		{name: "静态 token", bearer: "static-token", want: "ci", role: RoleReleaser},
+		{name: "错误的静态 token", bearer: "wrong-token", wantErr: true},
+		{name: "RSA 签名的 JWT", bearer: idp.sign(t, "rsa", nil), want: "alice", role: RoleViewer},
+		{name: "EC 签名的 JWT 和组", bearer: idp.sign(t, "ec", jwt.MapClaims{"sub": "bob", "groups": []string{"sre"}}), want: "bob", role: RoleAdmin},
+		{name: "过期的 JWT", bearer: idp.sign(t, "rsa", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}), wantErr: true},
+		{name: "没有有效期的 JWT", bearer: idp.sign(t, "rsa", jwt.MapClaims{"exp": nil}), wantErr: true},
+		{name: "错误的 issuer", bearer: idp.sign(t, "rsa", jwt.MapClaims{"iss": "https://other.example.com"}), wantErr: true},
+		{name: "错误的 audience", bearer: idp.sign(t, "rsa", jwt.MapClaims{"aud": "other"}), wantErr: true},
+		{name: "没有 sub", bearer: idp.sign(t, "rsa", jwt.MapClaims{"sub": nil}), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := authenticate(tt.bearer)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望认证失败，实际通过: %s", p.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("认证失败: %v", err)
+			}
+			if p.Name != tt.want {
+				t.Errorf("调用方为 %s，期望 %s", p.Name, tt.want)
+			}
+			if tt.role != "" && !p.HasRole(tt.role, "") {
+				t.Errorf("%s 没有 %s 角色", p.Name, tt.role)
+			}
+		})
+	}
+}
+
+func TestFetchKeysWithoutUsableKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []jwk{
+			{Kid: "ed", Kty: "OKP", Use: "sig", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(make([]byte, 32))},
+		}})
+	}))
+	defer server.Close()
+	o := &OIDC{Issuer: server.URL, JWKSURL: server.URL}
+	if keys, err := o.fetchKeys(); err == nil {
+		t.Fatalf("JWKS 中没有可用的公钥，期望返回错误，实际得到 %d 个公钥", len(keys))
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setConfig(t, Config{Tokens: []Token{
+		{Name: "admin", Token: "admin", Grant: Grant{Role: RoleAdmin}},
+		{Name: "viewer", Token: "viewer", Grant: Grant{Role: RoleViewer}},
+		{Name: "order", Token: "order", Grant: Grant{Role: RoleReleaser, Services: []string{"order-*"}}},
+		{Name: "staging", Token: "staging", Grant: Grant{Role: RoleReleaser, Environments: []string{"staging"}}},
+	}})
+
+	// 与发版接口一样：入口只检查角色，handler 再按请求的服务和环境检查
+	r := gin.New()
+	r.DELETE("/schedules/:code", Authenticate(), Require(RoleReleaser), func(c *gin.Context) {
+		if !Allowed(c, RoleReleaser, c.Query("service"), c.Query("environment")) {
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	r.GET("/services/:service/releases", Authenticate(), Require(RoleViewer), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		path   string
+		want   int
+	}{
+		{name: "没有 token", path: "/schedules/1?service=order-api&environment=prod", want: http.StatusUnauthorized},
+		{name: "admin", token: "admin", path: "/schedules/1?service=order-api&environment=prod", want: http.StatusOK},
+		{name: "admin 服务和环境未知", token: "admin", path: "/schedules/1", want: http.StatusOK},
+		{name: "viewer 不能操作", token: "viewer", path: "/schedules/1?service=order-api&environment=prod", want: http.StatusForbidden},
+		{name: "服务在范围内", token: "order", path: "/schedules/1?service=order-api&environment=prod", want: http.StatusOK},
+		{name: "服务不在范围内", token: "order", path: "/schedules/1?service=user-api&environment=prod", want: http.StatusForbidden},
+		{name: "服务未知时拒绝限制了服务的角色", token: "order", path: "/schedules/1?environment=prod", want: http.StatusForbidden},
+		{name: "环境在范围内", token: "staging", path: "/schedules/1?service=user-api&environment=staging", want: http.StatusOK},
+		{name: "环境不在范围内", token: "staging", path: "/schedules/1?service=user-api&environment=prod", want: http.StatusForbidden},
+		{name: "环境未知时拒绝限制了环境的角色", token: "staging", path: "/schedules/1?service=user-api", want: http.StatusForbidden},
+		{name: "路径中的服务在范围内", token: "order", method: http.MethodGet, path: "/services/order-api/releases", want: http.StatusOK},
+		{name: "路径中的服务不在范围内", token: "order", method: http.MethodGet, path: "/services/user-api/releases", want: http.StatusForbidden},
+		{name: "入口不检查环境", token: "staging", method: http.MethodGet, path: "/services/user-api/releases", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodDelete
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("返回 %d，期望 %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestCanAll(t *testing.T) {
+	p := &Principal{Name: "order", Grants: []Grant{{Role: RoleViewer, Services: []string{"order-*"}, Environments: []string{"staging"}}}}
+	tests := []struct {
+		name        string
+		services    []string
+		environment string
+		want        bool
+	}{
+		{name: "服务和环境都在范围内", services: []string{"order-api", "order-worker"}, environment: "staging", want: true},
+		{name: "有一个服务不在范围内", services: []string{"order-api", "user-api"}, environment: "staging", want: false},
+		{name: "环境不在范围内", services: []string{"order-api"}, environment: "prod", want: false},
+		{name: "环境未知", services: []string{"order-api"}, want: false},
+		{name: "没有服务时按服务未知检查", environment: "staging", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.CanAll(RoleViewer, tt.services, tt.environment); got != tt.want {
+				t.Errorf("CanAll 返回 %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+	var nobody *Principal
+	if nobody.CanAll(RoleViewer, []string{"order-api"}, "staging") {
+		t.Error("没有认证的调用方不应允许")
+	}
+}
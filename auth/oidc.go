@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// JWKS 缓存的公钥找不到 kid 时重新获取，两次获取至少间隔这么久
+const jwksRefreshInterval = time.Minute
+
+// OIDC 校验 OIDC 身份提供方签发的 JWT
+type OIDC struct {
+	Issuer string `json:"issuer"`
+	// Audience 为空时不检查 aud
+	Audience string `json:"audience,omitempty"`
+	// JWKSURL 为空时从 issuer 的 /.well-known/openid-configuration 获取
+	JWKSURL string `json:"jwksURL,omitempty"`
+	// UserClaim 用户名所在的 claim，默认 sub
+	UserClaim string `json:"userClaim,omitempty"`
+	// GroupsClaim 组所在的 claim，默认 groups
+	GroupsClaim string `json:"groupsClaim,omitempty"`
+
+	mu        sync.Mutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+}
+
+// withDefaults 补全默认 claim，返回新的对象
+func (o *OIDC) withDefaults() *OIDC {
+	c := &OIDC{
+		Issuer:      o.Issuer,
+		Audience:    o.Audience,
+		JWKSURL:     o.JWKSURL,
+		UserClaim:   o.UserClaim,
+		GroupsClaim: o.GroupsClaim,
+	}
+	if c.UserClaim == "" {
+		c.UserClaim = "sub"
+	}
+	if c.GroupsClaim == "" {
+		c.GroupsClaim = "groups"
+	}
+	return c
+}
+
+// verify 校验 JWT 的签名、issuer、audience 和有效期，返回用户名和组
+func (o *OIDC) verify(token string) (string, []string, error) {
+	options := []jwt.ParserOption{
+		jwt.WithIssuer(o.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if o.Audience != "" {
+		options = append(options, jwt.WithAudience(o.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, o.key, options...); err != nil {
+		return "", nil, fmt.Errorf("JWT 校验失败: %w", err)
+	}
+
+	user, _ := claims[o.UserClaim].(string)
+	if user == "" {
+		return "", nil, fmt.Errorf("JWT 中没有 %s", o.UserClaim)
+	}
+	var groups []string
+	switch v := claims[o.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	case string:
+		groups = strings.Fields(v)
+	}
+	return user, groups, nil
+}
+
+// key 按 JWT 头中的 kid 找到公钥，缓存中没有时重新获取 JWKS
+func (o *OIDC) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if key, ok := o.lookup(kid); ok {
+		return key, nil
+	}
+	if time.Since(o.fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("找不到 kid %s 对应的公钥", kid)
+	}
+	keys, err := o.fetchKeys()
+	o.fetchedAt = time.Now()
+	if err != nil {
+		return nil, err
+	}
+	o.keys = keys
+	if key, ok := o.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("找不到 kid %s 对应的公钥", kid)
+}
+
+// lookup 在缓存中查找公钥，JWT 没有 kid 且只有一个公钥时使用这个公钥，调用方需要持有 o.mu
+func (o *OIDC) lookup(kid string) (interface{}, bool) {
+	if kid == "" && len(o.keys) == 1 {
+		for _, key := range o.keys {
+			return key, true
+		}
+	}
+	key, ok := o.keys[kid]
+	return key, ok
+}
+
+// 获取 JWKS 时使用的客户端
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+// fetchKeys 获取 JWKS 并解析其中的 RSA 和 EC 公钥；不支持的公钥(例如 OKP/Ed25519)记录日志后跳过，
+// 没有一个可用的公钥时返回错误
+func (o *OIDC) fetchKeys() (map[string]interface{}, error) {
+	jwksURL := o.JWKSURL
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := getJSON(strings.TrimSuffix(o.Issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, err
+		}
+		if discovery.JWKSURI == "" {
+			return nil, fmt.Errorf("OIDC 配置中没有 jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := getJSON(jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("跳过 JWKS 中的公钥 %s: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s 中没有可用的签名公钥", jwksURL)
+	}
+	return keys, nil
+}
+
+func getJSON(url string, v interface{}) error {
+	resp, err := jwksClient.Get(url)
+	if err != nil {
+		return fmt.Errorf("请求 %s 失败: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求 %s 返回 %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("解析 %s 的响应失败: %w", url, err)
+	}
+	return nil
+}
+
+// jwk JWKS 中的一个公钥
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的曲线 %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("公钥不在曲线 %s 上", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("不支持的公钥类型 %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,267 @@
+package dingtalk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testapi/approval"
+	"testapi/metrics"
+	"time"
+)
+
+// 钉钉开放平台应用的 AppKey/AppSecret 和 OA 审批模板的 processCode，processCode 为空时不启用钉钉审批
+const (
+	appKey      = ""
+	appSecret   = ""
+	processCode = ""
+)
+
+// 钉钉新版服务端 API 地址
+const apiBase = "https://api.dingtalk.com"
+
+// 单次查询审批实例 ID 的最大数量，钉钉限制为 20
+const maxResults = 20
+
+// Configured 是否配置了钉钉审批
+func Configured() bool {
+	return appKey != "" && appSecret != "" && processCode != ""
+}
+
+// Source 钉钉 OA 审批来源
+type Source struct {
+	AppKey      string
+	AppSecret   string
+	ProcessCode string
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+var _ approval.Source = (*Source)(nil)
+
+// NewSource 使用默认配置创建钉钉审批来源
+func NewSource() *Source {
+	return &Source{
+		AppKey:      appKey,
+		AppSecret:   appSecret,
+		ProcessCode: processCode,
+	}
+}
+
+// Name 审批来源名称
+func (s *Source) Name() string {
+	return "dingtalk"
+}
+
+// errorResponse 钉钉新版 API 出错时返回非 200 状态码和这个结构
+type errorResponse struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"requestid"`
+}
+
+// getAccessToken 获取应用的 accessToken，有效期内复用，提前 5 分钟刷新
+func (s *Source) getAccessToken(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.accessToken != "" && time.Now().Before(s.expiresAt) {
+		return s.accessToken, nil
+	}
+
+	var response struct {
+		AccessToken string `json:"accessToken"`
+		ExpireIn    int64  `json:"expireIn"`
+	}
+	err := s.do(ctx, "POST", "/v1.0/oauth2/accessToken", "", map[string]string{
+		"appKey":    s.AppKey,
+		"appSecret": s.AppSecret,
+	}, &response)
+	metrics.TokenRefreshed(metrics.SystemDingTalk, err)
+	if err != nil {
+		return "", fmt.Errorf("获取钉钉 accessToken 失败: %w", err)
+	}
+
+	s.accessToken = response.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(response.ExpireIn)*time.Second - 5*time.Minute)
+	return s.accessToken, nil
+}
+
+// do 调用钉钉新版 API，token 不为空时放在 x-acs-dingtalk-access-token 头中；出错时响应中的 code 计入接口错误指标
+func (s *Source) do(ctx context.Context, method, path, token string, requestBody, result interface{}) error {
+	var reader io.Reader
+	if requestBody != nil {
+		data, err := json.Marshal(requestBody)
+		if err != nil {
+			return fmt.Errorf("序列化请求失败: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiBase+path, reader)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("x-acs-dingtalk-access-token", token)
+	}
+
+	client := &http.Client{Transport: metrics.Transport(metrics.SystemDingTalk, nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResponse
+		if err := json.Unmarshal(body, &errResp); err == nil && errResp.Code != "" {
+			metrics.APIError(metrics.SystemDingTalk, method, errResp.Code)
+			return fmt.Errorf("请求失败: %s %s", errResp.Code, errResp.Message)
+		}
+		return fmt.Errorf("请求失败: %s, %s", resp.Status, string(body))
+	}
+
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	return nil
+}
+
+// ListInstances 分页列出时间段内发起的审批实例 ID
+func (s *Source) ListInstances(ctx context.Context, start, end time.Time) ([]string, error) {
+	token, err := s.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	var nextToken int64
+	for {
+		var response struct {
+			Success bool `json:"success"`
+			Result  struct {
+				List      []string `json:"list"`
+				NextToken string   `json:"nextToken"`
+			} `json:"result"`
+		}
+		err := s.do(ctx, "POST", "/v1.0/workflow/processes/instanceIds/query", token, map[string]interface{}{
+			"processCode": s.ProcessCode,
+			"startTime":   start.UnixMilli(),
+			"endTime":     end.UnixMilli(),
+			"nextToken":   nextToken,
+			"maxResults":  maxResults,
+		}, &response)
+		if err != nil {
+			return nil, fmt.Errorf("查询钉钉审批实例失败: %w", err)
+		}
+		ids = append(ids, response.Result.List...)
+
+		if response.Result.NextToken == "" {
+			return ids, nil
+		}
+		nextToken, err = strconv.ParseInt(response.Result.NextToken, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("解析 nextToken 失败: %w", err)
+		}
+	}
+}
+
+// processInstance 钉钉审批实例详情中用到的字段
+type processInstance struct {
+	Title            string      `json:"title"`
+	Status           string      `json:"status"`
+	Result           string      `json:"result"`
+	OriginatorUserID string      `json:"originatorUserId"`
+	FinishTime       string      `json:"finishTime"`
+	FormValues       []formValue `json:"formComponentValues"`
+	Tasks            []struct {
+		UserID string `json:"userId"`
+		Status string `json:"status"`
+		Result string `json:"result"`
+	} `json:"tasks"`
+}
+
+// GetInstance 获取审批实例详情并转换为统一的审批单结构
+func (s *Source) GetInstance(ctx context.Context, code string) (*approval.Instance, error) {
+	token, err := s.getAccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Success bool            `json:"success"`
+		Result  processInstance `json:"result"`
+	}
+	path := "/v1.0/workflow/processInstances?processInstanceId=" + url.QueryEscape(code)
+	if err := s.do(ctx, "GET", path, token, nil, &response); err != nil {
+		return nil, fmt.Errorf("获取钉钉审批实例失败: %w", err)
+	}
+	detail := response.Result
+
+	instance := &approval.Instance{
+		Source:       s.Name(),
+		ApprovalCode: s.ProcessCode,
+		Code:         code,
+		Status:       convertStatus(detail.Status, detail.Result),
+		Applicant:    approval.User{UserID: detail.OriginatorUserID},
+		Form:         parseForm(detail.FormValues),
+	}
+
+	// finishTime 格式为 2006-01-02T15:04Z
+	if detail.FinishTime != "" {
+		finishTime, err := time.Parse("2006-01-02T15:04Z", detail.FinishTime)
+		if err != nil {
+			log.Printf("解析 finishTime 字段失败: %v", err)
+		} else {
+			instance.EndTime = finishTime
+		}
+	}
+
+	// 只有已同意的任务算作审批人，待审批、已拒绝、已转交的不算
+	seen := make(map[string]bool)
+	for _, task := range detail.Tasks {
+		if task.Status != "COMPLETED" || !strings.EqualFold(task.Result, "agree") {
+			continue
+		}
+		if task.UserID == "" || seen[task.UserID] {
+			continue
+		}
+		seen[task.UserID] = true
+		instance.Approvers = append(instance.Approvers, approval.User{UserID: task.UserID})
+	}
+
+	return instance, nil
+}
+
+// convertStatus 把钉钉的 status/result 转换为统一的审批状态
+func convertStatus(status, result string) string {
+	switch status {
+	case "RUNNING", "NEW":
+		return approval.StatusPending
+	case "COMPLETED":
+		if result == "agree" {
+			return approval.StatusApproved
+		}
+		return approval.StatusRejected
+	case "TERMINATED", "CANCELED":
+		return approval.StatusCanceled
+	default:
+		return status
+	}
+}
@@ -0,0 +1,96 @@
+package dingtalk
+
+import (
+	"encoding/json"
+	"strings"
+	"testapi/approval"
+	"time"
+)
+
+// formValue 钉钉审批实例中的一个表单控件
+type formValue struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Value         string `json:"value"`
+	ComponentType string `json:"componentType"`
+	ExtValue      string `json:"extValue"`
+}
+
+// tableRow 明细控件值中的一行
+type tableRow struct {
+	RowValue []struct {
+		Label string `json:"label"`
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"rowValue"`
+}
+
+// 钉钉日期控件可能只精确到天，也可能精确到分钟
+var dateLayouts = []string{"2006-01-02 15:04", "2006-01-02"}
+
+// parseForm 把钉钉表单控件转换为统一的表单
+func parseForm(values []formValue) approval.Form {
+	form := make(approval.Form, 0, len(values))
+	for _, value := range values {
+		form = append(form, convertField(value))
+	}
+	return form
+}
+
+// convertField 按钉钉控件类型转换控件值，多选、日期区间、明细的值是 JSON 字符串
+func convertField(value formValue) approval.Field {
+	field := approval.Field{ID: value.ID, Name: value.Name, Value: value.Value}
+
+	switch value.ComponentType {
+	case "TextField", "TextareaField", "PhoneField":
+		field.Type = approval.FieldText
+	case "NumberField", "MoneyField", "CalculateField":
+		field.Type = approval.FieldNumber
+	case "DDSelectField":
+		field.Type = approval.FieldSelect
+	case "DDMultiSelectField":
+		field.Type = approval.FieldMultiSelect
+		if err := json.Unmarshal([]byte(value.Value), &field.Values); err != nil {
+			field.Values = strings.Split(value.Value, ",")
+		}
+	case "InnerContactField":
+		field.Type = approval.FieldContact
+		field.Values = strings.Split(value.Value, ",")
+	case "DDDateField":
+		field.Type = approval.FieldDate
+		field.Start = parseDate(value.Value)
+	case "DDDateRangeField":
+		field.Type = approval.FieldDateInterval
+		var dates []string
+		if err := json.Unmarshal([]byte(value.Value), &dates); err == nil && len(dates) == 2 {
+			field.Start, field.End = parseDate(dates[0]), parseDate(dates[1])
+		}
+	case "TableField":
+		field.Type = approval.FieldTable
+		var rows []tableRow
+		if err := json.Unmarshal([]byte(value.Value), &rows); err != nil {
+			field.Type = approval.FieldUnknown
+			break
+		}
+		for _, row := range rows {
+			var cells approval.Form
+			for _, cell := range row.RowValue {
+				cells = append(cells, approval.Field{ID: cell.Key, Name: cell.Label, Type: approval.FieldText, Value: cell.Value})
+			}
+			field.Rows = append(field.Rows, cells)
+		}
+	default:
+		field.Type = approval.FieldUnknown
+	}
+	return field
+}
+
+// parseDate 解析钉钉日期控件的值，无法解析时返回零值
+func parseDate(value string) time.Time {
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,390 @@
+package feishu
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"testapi/audit"
+	"testapi/auth"
+	"testapi/gate"
+	"testapi/k8s"
+	"testapi/leader"
+	"testapi/queue"
+	sendmsg "testapi/sedmsg"
+	"testapi/state"
+	"testapi/store"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cardVerificationToken 飞书开发者后台 -> 事件与回调 -> 加密策略 中的 Verification Token，通过 SetCardVerificationToken 配置；
+// 没有配置时拒绝所有卡片回调
+var cardVerificationToken string
+
+// SetCardVerificationToken 设置卡片回调的 Verification Token，启动时调用
+func SetCardVerificationToken(token string) {
+	cardVerificationToken = strings.TrimSpace(token)
+}
+
+// 回调请求时间戳允许的最大偏差，超过则认为是重放请求
+const cardCallbackMaxSkew = 5 * time.Minute
+
+// cardActionAllowlist 允许点击发版卡片按钮的用户，key 可以是 user_id 也可以是 open_id，通过 SetCardActionAllowlist 配置；
+// 不在名单中的用户按认证配置中 feishu:<user_id> 或 feishu:<open_id> 的角色绑定检查 releaser 权限
+var cardActionAllowlist = map[string]bool{}
+
+// SetCardActionAllowlist 设置允许点击发版卡片按钮的用户，空字符串忽略，启动时调用
+func SetCardActionAllowlist(users []string) {
+	allowlist := make(map[string]bool, len(users))
+	for _, user := range users {
+		if user = strings.TrimSpace(user); user != "" {
+			allowlist[user] = true
+		}
+	}
+	cardActionAllowlist = allowlist
+}
+
+// CardActionRequest 卡片回调请求体，URL 校验请求也使用同一个结构
+type CardActionRequest struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Token     string `json:"token"`
+
+	OpenID        string `json:"open_id"`
+	UserID        string `json:"user_id"`
+	OpenMessageID string `json:"open_message_id"`
+	OpenChatID    string `json:"open_chat_id"`
+	Action        struct {
+		Tag   string            `json:"tag"`
+		Value map[string]string `json:"value"`
+	} `json:"action"`
+}
+
+// 按钮对应的操作名称，用于卡片展示
+var cardActionNames = map[string]string{
+	"rollback": "回滚",
+	"retry":    "重试",
+	"pods":     "查看 Pod",
+	"promote":  "转正",
+}
+
+// claimCardNonce 记录回调请求的 nonce，同一个 nonce 在时间戳有效期内再次出现时认为是重放请求；
+// 保存在存储中，多副本之间共享
+func claimCardNonce(ctx context.Context, nonce string) (bool, error) {
+	if nonce == "" {
+		return false, nil
+	}
+	return store.Default().Claim(ctx, "card-nonce:"+nonce, "card", 2*cardCallbackMaxSkew)
+}
+
+// verifyCardSignature 校验卡片回调签名: sha1(timestamp + nonce + token + body)
+func verifyCardSignature(timestamp, nonce, signature string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	skew := time.Since(time.Unix(ts, 0))
+	if skew > cardCallbackMaxSkew || skew < -cardCallbackMaxSkew {
+		return false
+	}
+
+	h := sha1.New()
+	h.Write([]byte(timestamp + nonce + cardVerificationToken))
+	h.Write(body)
+	expected := hex.EncodeToString(h.Sum(nil))
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) == 1
+}
+
+// CardActionHandler 处理发版卡片上的按钮点击
+func CardActionHandler(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取请求失败"})
+		return
+	}
+
+	var request CardActionRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解析请求失败"})
+		return
+	}
+
+	if cardVerificationToken == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "没有配置卡片回调的 Verification Token"})
+		return
+	}
+
+	// 配置回调地址时飞书会先发送 URL 校验请求，此时没有签名头
+	if request.Type == "url_verification" {
+		if subtle.ConstantTimeCompare([]byte(request.Token), []byte(cardVerificationToken)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "token 校验失败"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"challenge": request.Challenge})
+		return
+	}
+
+	nonce := c.GetHeader("X-Lark-Request-Nonce")
+	if !verifyCardSignature(
+		c.GetHeader("X-Lark-Request-Timestamp"),
+		nonce,
+		c.GetHeader("X-Lark-Signature"),
+		body,
+	) {
+		log.Printf("卡片回调签名校验失败, open_id: %s", request.OpenID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "签名校验失败"})
+		return
+	}
+	// 签名通过后再记录 nonce，避免伪造的请求占用 nonce
+	if fresh, err := claimCardNonce(c.Request.Context(), nonce); err != nil {
+		log.Printf("记录卡片回调 nonce 失败: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "记录请求失败"})
+		return
+	} else if !fresh {
+		log.Printf("卡片回调 nonce %q 重复，拒绝重放请求, open_id: %s", nonce, request.OpenID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "重复的请求"})
+		return
+	}
+
+	value := request.Action.Value
+	action, jobName, versionNumber := value["action"], value["job"], value["version"]
+
+	// 单聊通知上的退订和恢复按钮，任何用户都可以操作自己的通知
+	if action == actionOptOut || action == actionOptIn {
+		c.JSON(http.StatusOK, toggleOptOut(c.Request.Context(), request.OpenID, action))
+		return
+	}
+	actionName, ok := cardActionNames[action]
+	if !ok || jobName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("未知的卡片操作: %s", action)})
+		return
+	}
+
+	if !cardActionAllowed(request, value["instance"], jobName) {
+		log.Printf("用户 %s 没有权限执行卡片操作 %s %s", request.OpenID, action, jobName)
+		message := fmt.Sprintf("你没有权限对 %s 执行%s操作，请联系管理员", jobName, actionName)
+		if err := SendUserMsg("open_id", request.OpenID, sendmsg.BuildCard(message, "red")); err != nil {
+			log.Printf("给用户 %s 发送无权限提示失败: %v", request.OpenID, err)
+		}
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+
+	// 进程停止时等后台的操作结束后才释放主副本身份，停止过程中不再接受新的操作
+	release, err := leader.Hold()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	log.Printf("用户 %s 在审批单 %s 的卡片上对 %s 执行%s", request.OpenID, value["instance"], jobName, actionName)
+
+	// 飞书要求 3 秒内响应，操作在后台执行，结果通过更新卡片展示
+	card, found := LookupReleaseCard(request.OpenMessageID)
+	target := k8s.Target{Cluster: value["cluster"], Namespace: value["namespace"]}
+	operator := request.UserID
+	if operator == "" {
+		operator = request.OpenID
+	}
+	go func() {
+		defer release()
+		runCardAction(card, request.OpenChatID, value["instance"], operator, action, target, jobName, versionNumber)
+	}()
+
+	if !found {
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+	c.JSON(http.StatusOK, card.Card())
+}
+
+// toggleOptOut 退订或恢复点击人的单聊通知，返回替换原卡片的新卡片；按 open_id 记录，与通知时的去重一致
+func toggleOptOut(ctx context.Context, openID, action string) map[string]interface{} {
+	if action == actionOptOut {
+		if err := OptOut(ctx, openID); err != nil {
+			log.Printf("用户 %s 退订发版通知失败: %v", openID, err)
+			return optOutCard(sendmsg.BuildCard(fmt.Sprintf("退订失败，请稍后重试: %v", err), "red"))
+		}
+		log.Printf("用户 %s 退订了发版通知", openID)
+		return withButton(sendmsg.BuildCard("已退订发版结果通知，之后不会再单聊通知你", "grey"), "恢复接收发版通知", actionOptIn)
+	}
+	if err := OptIn(ctx, openID); err != nil {
+		log.Printf("用户 %s 恢复发版通知失败: %v", openID, err)
+		return withButton(sendmsg.BuildCard(fmt.Sprintf("恢复失败，请稍后重试: %v", err), "red"), "恢复接收发版通知", actionOptIn)
+	}
+	log.Printf("用户 %s 恢复了发版通知", openID)
+	return optOutCard(sendmsg.BuildCard("已恢复接收发版结果通知", "green"))
+}
+
+// cardActionAllowed 用户在白名单中，或者拥有服务在审批单发版环境下的 releaser 权限
+func cardActionAllowed(request CardActionRequest, instanceCode, jobName string) bool {
+	if cardActionAllowlist[request.OpenID] || cardActionAllowlist[request.UserID] {
+		return true
+	}
+	service := strings.TrimSuffix(jobName, k8s.GrayLevelSuffix)
+	return auth.FeishuUser(request.UserID, request.OpenID).Can(auth.RoleReleaser, service, releaseEnvironment(instanceCode))
+}
+
+// releaseEnvironment 审批单的发版环境，读不到发版状态时为空
+func releaseEnvironment(instanceCode string) string {
+	if instanceCode == "" {
+		return ""
+	}
+	rel, err := state.Get(instanceCode)
+	if err != nil || rel == nil {
+		return ""
+	}
+	return rel.CurrentEnvironment()
+}
+
+// runCardAction 执行按钮对应的操作并写审计记录；存储中找不到原卡片时，结果作为新消息发到群里
+func runCardAction(card *ReleaseCard, chatID, instanceCode, operator, action string, target k8s.Target, jobName, versionNumber string) {
+	// set 把操作进度写回卡片，没有卡片时只保留最终结果
+	var result, colors string
+	set := func(status, detail string) {
+		result = detail
+		colors = "green"
+		if status == k8s.StatusFailed {
+			colors = "red"
+		}
+		if card != nil {
+			card.Set(jobName, status, detail)
+		}
+		recordServicePhase(instanceCode, target, jobName, status, detail)
+	}
+	var report k8s.ProgressFunc
+	if card != nil {
+		report = card.Update
+	}
+
+	// 没有卡片时把最终结果发到群里
+	defer func() {
+		if card == nil && chatID != "" {
+			if _, err := SendCardMsg("chat_id", chatID, sendmsg.BuildCard(result, colors)); err != nil {
+				log.Printf("发送卡片操作结果到群 %s 失败: %v", chatID, err)
+			}
+		}
+	}()
+
+	// 重试、回滚、转正会修改 Deployment，与发版共用服务的队列；转正修改的是正式服务
+	if action != "pods" {
+		service := jobName
+		if action == "promote" {
+			service = strings.TrimSuffix(jobName, k8s.GrayLevelSuffix)
+		}
+		ticket := queue.Enqueue(queue.Entry{
+			Service:      queue.Key(service, target),
+			Version:      versionNumber,
+			InstanceCode: instanceCode,
+			Environment:  releaseEnvironment(instanceCode),
+			Action:       action,
+		})
+		if ahead := ticket.Ahead(); ahead > 0 && card != nil {
+			card.SetDetail(jobName, fmt.Sprintf("排队中，前面还有 %d 个操作", ahead))
+		}
+		if err := ticket.Wait(); err != nil {
+			set(k8s.StatusFailed, fmt.Sprintf("操作未执行: %v", err))
+			return
+		}
+		defer ticket.Done()
+	}
+
+	// 重试和转正与正常发版一样受发版策略约束，回滚和查看 Pod 不检查
+	if action == "retry" || action == "promote" {
+		rel, err := state.Get(instanceCode)
+		if err == nil {
+			err = gate.Check(rel, jobName)
+		}
+		if err != nil {
+			log.Printf("审批单 %s 的 %s 不允许%s: %v", instanceCode, jobName, cardActionNames[action], err)
+			set(k8s.StatusFailed, fmt.Sprintf("操作未执行: %v", err))
+			return
+		}
+	}
+
+	// record 写审计记录
+	started := time.Now()
+	record := func(auditAction, service string, change k8s.ImageChange, err error) {
+		audit.Record(audit.Operation{
+			InstanceCode: instanceCode,
+			Action:       auditAction,
+			Operator:     operator,
+			JobName:      service,
+			Version:      versionNumber,
+			Target:       target,
+			Change:       change,
+			StartedAt:    started,
+			Err:          err,
+		})
+	}
+
+	switch action {
+	case "rollback":
+		change, err := k8s.RollbackDeployment(target, jobName)
+		record(audit.ActionRollback, jobName, change, err)
+		if err != nil {
+			set(k8s.StatusFailed, fmt.Sprintf("回滚失败: %v", err))
+		} else {
+			set(k8s.StatusRolledBack, fmt.Sprintf("已回滚到 %s", k8s.FormatImages(change.To)))
+		}
+	case "retry":
+		set(k8s.StatusPending, "重试中")
+		change, err := k8s.Deploy(target, jobName, versionNumber, report)
+		record(audit.ActionRetry, jobName, change, err)
+		if err != nil {
+			set(k8s.StatusFailed, fmt.Sprintf("重试失败: %v", err))
+		} else {
+			set(k8s.StatusReady, fmt.Sprintf("重试成功: %s %s", jobName, versionNumber))
+		}
+	case "pods":
+		pods, err := k8s.DescribeDeploymentPods(target, jobName)
+		if err != nil {
+			pods = fmt.Sprintf("查看 Pod 失败: %v", err)
+		}
+		result, colors = pods, "blue"
+		if card != nil {
+			card.SetDetail(jobName, pods)
+		}
+	case "promote":
+		// 正式 Deployment 不在进度表中，进度记录在灰度服务这一行
+		promote := func(promoted, status, detail string) {
+			set(status, fmt.Sprintf("转正 %s: %s", promoted, detail))
+		}
+		change, err := k8s.PromoteGrayLevel(target, jobName, versionNumber, promote)
+		record(audit.ActionPromote, strings.TrimSuffix(jobName, k8s.GrayLevelSuffix), change, err)
+		if err != nil {
+			set(k8s.StatusFailed, fmt.Sprintf("转正失败: %v", err))
+		}
+	}
+}
+
+// recordServicePhase 把卡片操作的结果同步到审批单的发版状态
+func recordServicePhase(instanceCode string, target k8s.Target, jobName, status, detail string) {
+	phases := map[string]state.Phase{
+		k8s.StatusPending:    state.PhaseDeploying,
+		k8s.StatusReady:      state.PhaseSucceeded,
+		k8s.StatusFailed:     state.PhaseFailed,
+		k8s.StatusRolledBack: state.PhaseRolledBack,
+	}
+	phase, ok := phases[status]
+	if !ok || instanceCode == "" {
+		return
+	}
+	rel, err := state.Get(instanceCode)
+	if err != nil || rel == nil {
+		return
+	}
+	errMsg := ""
+	if status == k8s.StatusFailed {
+		errMsg = detail
+	}
+	rel.ServiceTransition(jobName, target.Cluster, target.Namespace, phase, errMsg)
+}
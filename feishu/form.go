@@ -0,0 +1,135 @@
+package feishu
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"testapi/approval"
+	"time"
+)
+
+// FormField 飞书审批表单中的一个控件，value 的结构随控件类型变化
+type FormField struct {
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// dateIntervalValue 日期区间控件的值
+type dateIntervalValue struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// parseForm 解析审批单详情中的 form 字段
+func parseForm(formStr string) (approval.Form, error) {
+	var fields []FormField
+	if err := json.Unmarshal([]byte(formStr), &fields); err != nil {
+		return nil, fmt.Errorf("解析form字段失败: %w", err)
+	}
+
+	form := make(approval.Form, 0, len(fields))
+	for _, field := range fields {
+		converted, err := convertField(field)
+		if err != nil {
+			return nil, fmt.Errorf("解析控件 %s(%s) 失败: %w", field.Name, field.Type, err)
+		}
+		form = append(form, converted)
+	}
+	return form, nil
+}
+
+// convertField 按飞书控件类型把控件值转换为统一的表单控件
+func convertField(raw FormField) (approval.Field, error) {
+	field := approval.Field{ID: raw.ID, Name: raw.Name}
+	if len(raw.Value) == 0 || string(raw.Value) == "null" {
+		field.Type = approval.FieldUnknown
+		return field, nil
+	}
+
+	switch raw.Type {
+	case "input", "textarea", "mail", "telephone":
+		field.Type = approval.FieldText
+		return field, json.Unmarshal(raw.Value, &field.Value)
+	case "number", "amount", "formula":
+		// 数字类控件的值可能是数字也可能是字符串
+		field.Type = approval.FieldNumber
+		field.Value = strings.Trim(string(raw.Value), `"`)
+		return field, nil
+	case "radio", "radioV2":
+		field.Type = approval.FieldSelect
+		return field, json.Unmarshal(raw.Value, &field.Value)
+	case "checkbox", "checkboxV2":
+		field.Type = approval.FieldMultiSelect
+		return field, json.Unmarshal(raw.Value, &field.Values)
+	case "contact":
+		field.Type = approval.FieldContact
+		return field, json.Unmarshal(raw.Value, &field.Values)
+	case "date":
+		field.Type = approval.FieldDate
+		if err := json.Unmarshal(raw.Value, &field.Value); err != nil {
+			return field, err
+		}
+		start, err := parseDate(field.Value)
+		field.Start = start
+		return field, err
+	case "dateInterval":
+		field.Type = approval.FieldDateInterval
+		var interval dateIntervalValue
+		if err := json.Unmarshal(raw.Value, &interval); err != nil {
+			return field, err
+		}
+		start, err := parseDate(interval.Start)
+		if err != nil {
+			return field, err
+		}
+		end, err := parseDate(interval.End)
+		if err != nil {
+			return field, err
+		}
+		field.Start, field.End = start, end
+		return field, nil
+	case "fieldList":
+		field.Type = approval.FieldTable
+		var rows [][]FormField
+		if err := json.Unmarshal(raw.Value, &rows); err != nil {
+			return field, err
+		}
+		for _, row := range rows {
+			var cells approval.Form
+			for _, cell := range row {
+				converted, err := convertField(cell)
+				if err != nil {
+					return field, fmt.Errorf("解析明细控件 %s 失败: %w", cell.Name, err)
+				}
+				cells = append(cells, converted)
+			}
+			field.Rows = append(field.Rows, cells)
+		}
+		return field, nil
+	default:
+		// 说明、附件等与发版无关的控件，字符串原样保留，其他结构保留原始 JSON
+		field.Type = approval.FieldUnknown
+		if err := json.Unmarshal(raw.Value, &field.Value); err != nil {
+			field.Value = string(raw.Value)
+		}
+		return field, nil
+	}
+}
+
+// parseDate 解析飞书日期控件的值，格式为 RFC3339，也兼容毫秒时间戳
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无法解析日期: %s", value)
+	}
+	return time.UnixMilli(ms), nil
+}
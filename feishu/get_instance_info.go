@@ -1,12 +1,13 @@
 package feishu
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"testapi/approval"
 	"time"
 )
 
@@ -16,30 +17,16 @@ type JobData struct {
 	VersionNumber string `json:"VersionNumber"`
 }
 
-// FormField 结构体定义
-type FormField struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Value string `json:"value"`
-}
-
 // JobDatak8s 结构体定义
 type JobDatak8s struct {
 	JobNameAndVersionNumber string `json:"JobNameAndVersionNumber"`
 }
 
-// FormFieldk8s 结构体定义
-type FormFieldk8s struct {
-	ID    string `json:"id"`
-	Name  string `json:"name"`
-	Value string `json:"value"`
-}
-
-// GetProjectInfo 函数
-func GetProjectInfo(instanceCode string, useK8sFormat bool) (map[string]map[string]string, error) {
+// getInstanceDetail 获取审批单详情，返回响应中的 data 部分
+func getInstanceDetail(ctx context.Context, instanceCode string) (map[string]interface{}, error) {
 	url := fmt.Sprintf("https://open.feishu.cn/open-apis/approval/v4/instances/%s", instanceCode)
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("创建请求失败: %w", err)
 	}
@@ -51,16 +38,9 @@ func GetProjectInfo(instanceCode string, useK8sFormat bool) (map[string]map[stri
 	}
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tenantAccessToken))
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	body, err := send(req)
 	if err != nil {
-		return nil, fmt.Errorf("发送请求失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("读取响应失败: %w", err)
+		return nil, err
 	}
 
 	// 打印完整的响应体
@@ -87,57 +67,36 @@ func GetProjectInfo(instanceCode string, useK8sFormat bool) (map[string]map[stri
 		return nil, fmt.Errorf("响应数据格式错误: 'data' 字段不是预期的 map 类型")
 	}
 	fmt.Println("审批单信息：", data)
+	return data, nil
+}
 
-	// 获取当前时间
-	now := time.Now().UTC()
-	nowTimestamp := now.UnixNano() / int64(time.Millisecond)
-
-	// 初始化结果
-	unexpiredResults := make(map[string]map[string]string)
+// GetInstance 获取审批单详情并解析表单
+func (s *Source) GetInstance(ctx context.Context, instanceCode string) (*approval.Instance, error) {
+	data, err := getInstanceDetail(ctx, instanceCode)
+	if err != nil {
+		return nil, err
+	}
 
-	// 直接处理单个审批单的信息
-	// 获取审批单的结束时间
-	var endTime int64
-	if endTimeInterface, ok := data["end_time"]; ok {
-		switch v := endTimeInterface.(type) {
-		case float64:
-			endTime = int64(v * 1000) // 假设 end_time 是秒级时间戳
-		case int64:
-			endTime = v
-		case string:
-			endTimeInt, err := strconv.ParseInt(v, 10, 64)
-			if err != nil {
-				log.Printf("解析 end_time 字段失败: %v", err)
-				// 如果类型未知，默认继续处理
-				endTime = 0
-			} else {
-				endTime = endTimeInt
-			}
-		default:
-			log.Printf("响应数据格式警告: 'end_time' 字段类型未知，将忽略此字段并继续处理")
-			// 如果类型未知，默认继续处理
-			endTime = 0
-		}
-	} else {
-		// 如果 end_time 不存在，认为审批单仍在审批中
-		endTime = 0
+	instance := &approval.Instance{
+		Source: s.Name(),
 	}
 
-	// 比较结束时间和当前时间，加上 1 小时的缓冲时间
-	bufferedEndTime := endTime + int64(60*1000) // 1 小时的缓冲时间
-	if bufferedEndTime > 0 && bufferedEndTime < nowTimestamp {
-		// 审批单已过期，跳过
-		return unexpiredResults, nil
+	// 获取审批单的结束时间
+	if endTime := parseEndTime(data); endTime > 0 {
+		instance.EndTime = time.UnixMilli(endTime)
 	}
 
-	// 审批单未过期
-	unexpiredResult := make(map[string]string)
-	// 获取状态
+	// 获取状态，飞书的状态与统一状态一致，撤回对应 CANCELED
 	status, ok := data["status"].(string)
 	if !ok {
 		return nil, fmt.Errorf("响应数据格式错误: 'status' 字段不是预期的 string 类型")
 	}
-	unexpiredResult["status"] = status
+	instance.Status = status
+
+	// 获取申请人和审批人，发版结果需要单独通知他们
+	instance.Applicant.UserID, _ = data["user_id"].(string)
+	instance.Applicant.OpenID, _ = data["open_id"].(string)
+	instance.Approvers = parseApprovers(data)
 
 	// 获取表单数据
 	formStr, ok := data["form"].(string)
@@ -145,45 +104,75 @@ func GetProjectInfo(instanceCode string, useK8sFormat bool) (map[string]map[stri
 		return nil, fmt.Errorf("响应数据格式错误: 'form' 字段不是预期的 string 类型")
 	}
 
-	// 根据useK8sFormat选择解析方式
-	var form []FormField
-	if useK8sFormat {
-		var formK8s []FormFieldk8s
-		if err := json.Unmarshal([]byte(formStr), &formK8s); err != nil {
-			return nil, fmt.Errorf("解析form字段失败: %w", err)
-		}
-		form = convertToFormFieldSlice(formK8s)
-	} else {
-		if err := json.Unmarshal([]byte(formStr), &form); err != nil {
-			return nil, fmt.Errorf("解析form字段失败: %w", err)
-		}
+	instance.Form, err = parseForm(formStr)
+	if err != nil {
+		return nil, err
 	}
 
-	// 提取表单字段
-	for _, field := range form {
-		unexpiredResult[field.Name] = field.Value
-	}
+	instance.ApprovalCode, _ = data["approval_code"].(string)
 
-	// 添加到未过期结果中
 	instanceID, ok := data["instance_code"].(string)
 	if !ok {
 		return nil, fmt.Errorf("响应数据格式错误: 'instance_code' 字段不是预期的 string 类型")
 	}
-	unexpiredResults[instanceID] = unexpiredResult
+	instance.Code = instanceID
 
-	// 返回结果
-	return unexpiredResults, nil
+	return instance, nil
+}
+
+// parseEndTime 获取审批单的结束时间，毫秒时间戳，仍在审批中时为 0
+func parseEndTime(data map[string]interface{}) int64 {
+	endTimeInterface, ok := data["end_time"]
+	if !ok {
+		// 如果 end_time 不存在，认为审批单仍在审批中
+		return 0
+	}
+
+	switch v := endTimeInterface.(type) {
+	case float64:
+		return int64(v * 1000) // 假设 end_time 是秒级时间戳
+	case int64:
+		return v
+	case string:
+		endTimeInt, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Printf("解析 end_time 字段失败: %v", err)
+			// 如果类型未知，默认继续处理
+			return 0
+		}
+		return endTimeInt
+	default:
+		log.Printf("响应数据格式警告: 'end_time' 字段类型未知，将忽略此字段并继续处理")
+		// 如果类型未知，默认继续处理
+		return 0
+	}
 }
 
-// convertToFormFieldSlice 将FormFieldk8s类型的切片转换为FormField类型的切片
-func convertToFormFieldSlice(formK8s []FormFieldk8s) []FormField {
-	result := make([]FormField, len(formK8s))
-	for i, v := range formK8s {
-		result[i] = FormField{
-			ID:    v.ID,
-			Name:  v.Name,
-			Value: v.Value,
+// parseApprovers 从审批单的 task_list 中提取已同意的审批人，按 open_id 去重；
+// 待审批、已转交、已拒绝的任务不算参与审批，发版策略中的审批人组只认这里的用户
+func parseApprovers(data map[string]interface{}) []approval.User {
+	tasks, ok := data["task_list"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var approvers []approval.User
+	for _, t := range tasks {
+		task, ok := t.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if status, _ := task["status"].(string); status != "APPROVED" {
+			continue
+		}
+		openID, ok := task["open_id"].(string)
+		if !ok || openID == "" || seen[openID] {
+			continue
 		}
+		seen[openID] = true
+		userID, _ := task["user_id"].(string)
+		approvers = append(approvers, approval.User{UserID: userID, OpenID: openID})
 	}
-	return result
+	return approvers
 }
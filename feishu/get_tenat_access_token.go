@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
+	"sync"
+	"testapi/metrics"
+	"time"
 )
 
 type AccessTokenRequest struct {
@@ -17,9 +19,41 @@ type AccessTokenResponse struct {
 	Code              int    `json:"code"`
 	Message           string `json:"msg"`
 	TenantAccessToken string `json:"tenant_access_token"`
+	// Expire 剩余有效期，单位秒
+	Expire int64 `json:"expire"`
 }
 
+// tenant_access_token 的缓存，有效期内复用，提前 5 分钟刷新
+var (
+	tokenMu        sync.Mutex
+	cachedToken    string
+	tokenExpiresAt time.Time
+)
+
+// GetTenantAccessToken 获取 tenant_access_token，有效期内复用缓存，过期前 5 分钟重新向飞书获取
 func GetTenantAccessToken() (string, error) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	if cachedToken != "" && time.Now().Before(tokenExpiresAt) {
+		return cachedToken, nil
+	}
+	response, err := fetchTenantAccessToken()
+	if err == nil && response.Code != 0 {
+		err = fmt.Errorf("获取 tenant_access_token 失败: %d %s", response.Code, response.Message)
+	}
+	metrics.TokenRefreshed(metrics.SystemFeishu, err)
+	if err != nil {
+		return "", err
+	}
+
+	cachedToken = response.TenantAccessToken
+	tokenExpiresAt = time.Now().Add(time.Duration(response.Expire)*time.Second - 5*time.Minute)
+	return cachedToken, nil
+}
+
+// fetchTenantAccessToken 向飞书获取 tenant_access_token
+func fetchTenantAccessToken() (*AccessTokenResponse, error) {
 	url := "https://open.feishu.cn/open-apis/auth/v3/tenant_access_token/internal"
 
 	// Create the request body as JSON
@@ -31,34 +65,23 @@ func GetTenantAccessToken() (string, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
 		fmt.Println("JSON encoding error:", err)
-		return "", err
+		return nil, err
 	}
 
 	// Create a new request with the JSON data
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
 	if err != nil {
 		fmt.Println("Creating request failed:", err)
-		return "", err
+		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 
-	// Create a new HTTP client
-	client := &http.Client{}
-
-	// Send the request
-	resp, err := client.Do(req)
+	// Send the request and read the response body
+	body, err := send(req)
 	if err != nil {
 		fmt.Println("Sending request failed:", err)
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Println("Reading response failed:", err)
-		return "", err
+		return nil, err
 	}
 
 	// Parse the response JSON
@@ -66,8 +89,8 @@ func GetTenantAccessToken() (string, error) {
 	err = json.Unmarshal(body, &accessTokenResponse)
 	if err != nil {
 		fmt.Println("Decoding JSON failed:", err)
-		return "", err
+		return nil, err
 	}
 
-	return accessTokenResponse.TenantAccessToken, nil
+	return &accessTokenResponse, nil
 }
@@ -0,0 +1,64 @@
+package feishu
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"testapi/metrics"
+	"testapi/retry"
+	"time"
+)
+
+// 飞书开放平台的频率限制错误码，等一会儿重试即可
+const codeRateLimited = 99991400
+
+// 所有飞书接口共用的客户端，单次请求最多等待 30 秒
+var httpClient = &http.Client{Timeout: 30 * time.Second, Transport: metrics.Transport(metrics.SystemFeishu, nil)}
+
+// send 发送请求并读取响应体；连接失败、超时、429、5xx 和频率限制按重试策略重试，
+// 请求体需要用 bytes.Buffer 等可以重复读取的类型创建。其他状态码照常返回响应体，由调用方解析错误信息；
+// 响应中的 code 不为 0 时计入接口错误指标。
+// 重试时之前的请求可能已经生效，不幂等的请求(例如发送消息)需要带上接口支持的去重参数
+func send(req *http.Request) ([]byte, error) {
+	var body []byte
+	attempt := 0
+	err := retry.Do(req.Context(), fmt.Sprintf("飞书接口 %s %s", req.Method, req.URL.Path), func() error {
+		attempt++
+		if attempt > 1 && req.GetBody != nil {
+			b, err := req.GetBody()
+			if err != nil {
+				return fmt.Errorf("重置请求失败: %w", err)
+			}
+			req.Body = b
+		}
+
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return retry.Retryable(fmt.Errorf("发送请求失败: %w", err))
+		}
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return retry.Retryable(fmt.Errorf("读取响应失败: %w", err))
+		}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError {
+			return retry.Retryable(fmt.Errorf("飞书接口返回 %s", resp.Status))
+		}
+		var result struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		if json.Unmarshal(data, &result) == nil && result.Code != 0 {
+			metrics.APIError(metrics.SystemFeishu, req.Method, strconv.Itoa(result.Code))
+			if result.Code == codeRateLimited {
+				return retry.Retryable(fmt.Errorf("飞书接口频率限制: %s", result.Msg))
+			}
+		}
+		body = data
+		return nil
+	})
+	return body, err
+}
@@ -0,0 +1,116 @@
+package feishu
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"testapi/auth"
+	"testapi/store"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 退订发版结果单聊通知的用户保存在存储的这个集合中，user_id 或 open_id -> 退订时间
+const optOutKey = "release:notify-optout"
+
+// 单聊通知卡片上的退订和恢复按钮
+const (
+	actionOptOut = "optout"
+	actionOptIn  = "optin"
+)
+
+// OptOut 退订发版结果单聊通知
+func OptOut(ctx context.Context, user string) error {
+	return store.Default().Put(ctx, optOutKey, user, time.Now().Format(time.RFC3339))
+}
+
+// OptIn 恢复接收发版结果单聊通知，没有退订时不报错
+func OptIn(ctx context.Context, user string) error {
+	return store.Default().Delete(ctx, optOutKey, user)
+}
+
+// OptedOut 用户是否已经退订，users 为同一个用户的 user_id、open_id，空字符串忽略
+func OptedOut(ctx context.Context, users ...string) (bool, error) {
+	for _, user := range users {
+		if user == "" {
+			continue
+		}
+		_, ok, err := store.Default().Get(ctx, optOutKey, user)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// OptOutsHandler 列出退订了发版结果单聊通知的用户
+func OptOutsHandler(c *gin.Context) {
+	entries, err := store.Default().List(c.Request.Context(), optOutKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	users := make([]gin.H, 0, len(entries))
+	for user, at := range entries {
+		users = append(users, gin.H{"user": user, "optedOutAt": at})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i]["user"].(string) < users[j]["user"].(string) })
+	c.JSON(http.StatusOK, gin.H{"optOuts": users})
+}
+
+// OptOutHandler 退订 :user 的发版结果单聊通知，admin 或者调用方就是这个用户时允许
+func OptOutHandler(c *gin.Context) {
+	user, ok := optOutUser(c)
+	if !ok {
+		return
+	}
+	if err := OptOut(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// OptInHandler 恢复 :user 的发版结果单聊通知，admin 或者调用方就是这个用户时允许
+func OptInHandler(c *gin.Context) {
+	user, ok := optOutUser(c)
+	if !ok {
+		return
+	}
+	if err := OptIn(c.Request.Context(), user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// optOutUser 返回路径中的用户，调用方既不是这个用户也不是 admin 时返回 403
+func optOutUser(c *gin.Context) (string, bool) {
+	user := c.Param("user")
+	if p := auth.Current(c); p != nil && p.Name == user {
+		return user, true
+	}
+	return user, auth.Allowed(c, auth.RoleAdmin, "", "")
+}
+
+// optOutCard 单聊通知卡片，附带退订按钮
+func optOutCard(card map[string]interface{}) map[string]interface{} {
+	return withButton(card, "不再接收发版通知", actionOptOut)
+}
+
+// withButton 在卡片末尾追加一个回调按钮，点击时回调 value 中带 action
+func withButton(card map[string]interface{}, text, action string) map[string]interface{} {
+	card["elements"] = append(card["elements"].([]interface{}), map[string]interface{}{
+		"tag": "action",
+		"actions": []interface{}{
+			map[string]interface{}{
+				"tag":   "button",
+				"text":  map[string]interface{}{"tag": "plain_text", "content": text},
+				"type":  "default",
+				"value": map[string]string{"action": action},
+			},
+		},
+	})
+	return card
+}
@@ -0,0 +1,245 @@
+package feishu
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"testapi/k8s"
+	sendmsg "testapi/sedmsg"
+	"testapi/store"
+)
+
+// 发版卡片保存在存储的这两个集合中：审批单号 -> 卡片的 JSON，message_id -> 审批单号；
+// 进程重启或者由其他副本处理按钮回调时据此找回卡片
+const (
+	cardKey        = "release:card"
+	cardMessageKey = "release:card-message"
+)
+
+// ReleaseCard 一个审批单对应一张发版卡片，发版开始时发送，之后随每个服务的状态变化原地更新
+type ReleaseCard struct {
+	InstanceCode string
+
+	mu       sync.Mutex
+	rows     []sendmsg.ProgressRow
+	messages map[string]string // chat_id -> message_id
+}
+
+// savedCard 保存在存储中的卡片
+type savedCard struct {
+	InstanceCode string                `json:"instanceCode"`
+	Rows         []sendmsg.ProgressRow `json:"rows"`
+	Messages     map[string]string     `json:"messages"`
+}
+
+var (
+	cardsMu sync.Mutex
+	// releaseCards 已经加载过的发版卡片，key 为审批单号，同一个审批单在进程内只有一个对象
+	releaseCards = map[string]*ReleaseCard{}
+)
+
+// LookupReleaseCard 根据 message_id 找到对应的发版卡片，内存中没有时从存储加载
+func LookupReleaseCard(messageID string) (*ReleaseCard, bool) {
+	if messageID == "" {
+		return nil, false
+	}
+	code, ok, err := store.Default().Get(context.TODO(), cardMessageKey, messageID)
+	if err != nil {
+		log.Printf("查找消息 %s 对应的发版卡片失败: %v", messageID, err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	card, err := loadReleaseCard(code)
+	if err != nil {
+		log.Printf("加载审批单 %s 的发版卡片失败: %v", code, err)
+		return nil, false
+	}
+	return card, card != nil
+}
+
+// loadReleaseCard 加载审批单的发版卡片，不存在时返回 nil
+func loadReleaseCard(instanceCode string) (*ReleaseCard, error) {
+	cardsMu.Lock()
+	defer cardsMu.Unlock()
+	if card, ok := releaseCards[instanceCode]; ok {
+		return card, nil
+	}
+
+	data, ok, err := store.Default().Get(context.TODO(), cardKey, instanceCode)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var saved savedCard
+	if err := json.Unmarshal([]byte(data), &saved); err != nil {
+		return nil, fmt.Errorf("解析审批单 %s 的发版卡片失败: %w", instanceCode, err)
+	}
+	card := &ReleaseCard{InstanceCode: instanceCode, rows: saved.Rows, messages: saved.Messages}
+	if card.messages == nil {
+		card.messages = make(map[string]string)
+	}
+	releaseCards[instanceCode] = card
+	return card, nil
+}
+
+// NewReleaseCard 创建发版卡片，rows 为本次要发版的服务，初始状态一般为 pending；
+// 审批单已经发过卡片时(例如重启后继续发版)沿用原来的卡片消息，Start 时原地更新而不是重新发送
+func NewReleaseCard(instanceCode string, rows []sendmsg.ProgressRow) *ReleaseCard {
+	card, err := loadReleaseCard(instanceCode)
+	if err != nil {
+		log.Printf("加载审批单 %s 的发版卡片失败，重新发送: %v", instanceCode, err)
+	}
+	if card != nil {
+		card.mu.Lock()
+		card.rows = rows
+		card.mu.Unlock()
+		return card
+	}
+
+	card = &ReleaseCard{
+		InstanceCode: instanceCode,
+		rows:         rows,
+		messages:     make(map[string]string),
+	}
+	cardsMu.Lock()
+	releaseCards[instanceCode] = card
+	cardsMu.Unlock()
+	return card
+}
+
+// Start 把卡片发送到每个服务所在的群，同一个群只发一次，已经发过的群原地更新；没有任何群配置 chat_id 时返回错误
+func (c *ReleaseCard) Start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 沿用的卡片先刷新成本次的发版内容
+	c.refresh()
+
+	card := sendmsg.BuildProgressCard(c.InstanceCode, c.rows, c.colors())
+	for _, row := range c.rows {
+		chatID := sendmsg.ResolveRoute(row.JobName).ChatID
+		if chatID == "" {
+			continue
+		}
+		if _, ok := c.messages[chatID]; ok {
+			continue
+		}
+		messageID, err := SendCardMsg("chat_id", chatID, card)
+		if err != nil {
+			log.Printf("发送发版卡片到群 %s 失败: %v", chatID, err)
+			continue
+		}
+		c.messages[chatID] = messageID
+		if err := store.Default().Put(context.TODO(), cardMessageKey, messageID, c.InstanceCode); err != nil {
+			log.Printf("保存发版卡片 %s 的消息索引失败: %v", messageID, err)
+		}
+	}
+	c.save()
+
+	if len(c.messages) == 0 {
+		return fmt.Errorf("审批单 %s 没有可用的群 chat_id，无法发送发版卡片", c.InstanceCode)
+	}
+	return nil
+}
+
+// Update 更新某个服务的状态并刷新所有已发送的卡片，签名与 k8s.ProgressFunc 一致
+func (c *ReleaseCard) Update(jobName, status, detail string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.rows {
+		if c.rows[i].JobName == jobName && !isFinished(c.rows[i].Status) {
+			c.rows[i].Status = status
+			c.rows[i].Detail = detail
+			break
+		}
+	}
+
+	c.refresh()
+}
+
+// Set 直接设置某个服务最后一行的状态，用于卡片按钮触发的重试、回滚等操作
+func (c *ReleaseCard) Set(jobName, status, detail string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.rows) - 1; i >= 0; i-- {
+		if c.rows[i].JobName == jobName {
+			c.rows[i].Status = status
+			c.rows[i].Detail = detail
+			break
+		}
+	}
+	c.refresh()
+}
+
+// SetDetail 只更新某个服务最后一行的详情，状态保持不变
+func (c *ReleaseCard) SetDetail(jobName, detail string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := len(c.rows) - 1; i >= 0; i-- {
+		if c.rows[i].JobName == jobName {
+			c.rows[i].Detail = detail
+			break
+		}
+	}
+	c.refresh()
+}
+
+// Card 返回当前的卡片内容
+func (c *ReleaseCard) Card() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return sendmsg.BuildProgressCard(c.InstanceCode, c.rows, c.colors())
+}
+
+// save 保存卡片，失败时只记录日志，调用方需持有锁
+func (c *ReleaseCard) save() {
+	data, err := json.Marshal(savedCard{InstanceCode: c.InstanceCode, Rows: c.rows, Messages: c.messages})
+	if err != nil {
+		log.Printf("序列化审批单 %s 的发版卡片失败: %v", c.InstanceCode, err)
+		return
+	}
+	if err := store.Default().Put(context.TODO(), cardKey, c.InstanceCode, string(data)); err != nil {
+		log.Printf("保存审批单 %s 的发版卡片失败: %v", c.InstanceCode, err)
+	}
+}
+
+// refresh 保存最新状态并更新所有已发送的卡片，调用方需持有锁
+func (c *ReleaseCard) refresh() {
+	c.save()
+	card := sendmsg.BuildProgressCard(c.InstanceCode, c.rows, c.colors())
+	for chatID, messageID := range c.messages {
+		if err := PatchCardMsg(messageID, card); err != nil {
+			log.Printf("更新群 %s 的发版卡片失败: %v", chatID, err)
+		}
+	}
+}
+
+// colors 根据整体进度决定卡片标题颜色：有失败为红色，全部就绪为绿色，否则为蓝色
+func (c *ReleaseCard) colors() string {
+	ready := 0
+	for _, row := range c.rows {
+		switch row.Status {
+		case k8s.StatusFailed:
+			return "red"
+		case k8s.StatusRolledBack:
+			return "orange"
+		case k8s.StatusReady:
+			ready++
+		}
+	}
+	if ready == len(c.rows) {
+		return "green"
+	}
+	return "blue"
+}
+
+// isFinished 判断服务是否已经结束发版，同一个服务出现多行时依次更新
+func isFinished(status string) bool {
+	return status == k8s.StatusReady || status == k8s.StatusFailed || status == k8s.StatusRolledBack || status == k8s.StatusSkipped
+}
@@ -0,0 +1,165 @@
+package feishu
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"testapi/approval"
+	sendmsg "testapi/sedmsg"
+)
+
+// SendMessageResponse 发送消息接口的响应
+type SendMessageResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data struct {
+		MessageID string `json:"message_id"`
+	} `json:"data"`
+}
+
+// SendUserMsg 通过机器人给单个用户发送卡片消息，receiveIDType 为 open_id、user_id 等
+func SendUserMsg(receiveIDType, receiveID string, card map[string]interface{}) error {
+	_, err := SendCardMsg(receiveIDType, receiveID, card)
+	return err
+}
+
+// SendCardMsg 通过机器人发送卡片消息并返回 message_id，后续可以用 PatchCardMsg 原地更新
+func SendCardMsg(receiveIDType, receiveID string, card map[string]interface{}) (string, error) {
+	url := fmt.Sprintf("https://open.feishu.cn/open-apis/im/v1/messages?receive_id_type=%s", receiveIDType)
+
+	// content 字段要求是卡片 JSON 序列化后的字符串
+	content, err := json.Marshal(card)
+	if err != nil {
+		return "", fmt.Errorf("序列化卡片失败: %w", err)
+	}
+	// 发送消息不是幂等的，send 超时重试时飞书按 uuid 去重，1 小时内同一个 uuid 最多发送一条消息
+	dedupe, err := messageUUID()
+	if err != nil {
+		return "", err
+	}
+	requestBody, err := json.Marshal(map[string]string{
+		"receive_id": receiveID,
+		"msg_type":   "interactive",
+		"content":    string(content),
+		"uuid":       dedupe,
+	})
+	if err != nil {
+		return "", fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return "", fmt.Errorf("创建请求失败: %w", err)
+	}
+
+	tenantAccessToken, err := GetTenantAccessToken()
+	if err != nil {
+		return "", fmt.Errorf("获取租户访问令牌失败: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tenantAccessToken))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	body, err := send(req)
+	if err != nil {
+		return "", err
+	}
+
+	var response SendMessageResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("解析响应失败: %w", err)
+	}
+	if response.Code != 0 {
+		return "", fmt.Errorf("请求失败: %s", response.Msg)
+	}
+	return response.Data.MessageID, nil
+}
+
+// messageUUID 生成发送消息请求的去重 uuid，同一次发送的每次重试使用同一个值
+func messageUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("生成消息去重 uuid 失败: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// PatchCardMsg 用新的卡片内容更新已发送的卡片消息
+func PatchCardMsg(messageID string, card map[string]interface{}) error {
+	url := fmt.Sprintf("https://open.feishu.cn/open-apis/im/v1/messages/%s", messageID)
+
+	content, err := json.Marshal(card)
+	if err != nil {
+		return fmt.Errorf("序列化卡片失败: %w", err)
+	}
+	requestBody, err := json.Marshal(map[string]string{
+		"content": string(content),
+	})
+	if err != nil {
+		return fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+
+	tenantAccessToken, err := GetTenantAccessToken()
+	if err != nil {
+		return fmt.Errorf("获取租户访问令牌失败: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tenantAccessToken))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	body, err := send(req)
+	if err != nil {
+		return err
+	}
+
+	var response SendMessageResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	if response.Code != 0 {
+		return fmt.Errorf("请求失败: %s", response.Msg)
+	}
+	return nil
+}
+
+// NotifyUsers 把发版结果单聊通知给审批单的申请人和审批人，跳过退订的用户；卡片上有退订按钮
+func (s *Source) NotifyUsers(ctx context.Context, instance *approval.Instance, message, colors string) error {
+	card := optOutCard(sendmsg.BuildCard(message, colors))
+
+	// 申请人可能同时也是审批人，按 open_id 去重
+	notified := make(map[string]bool)
+	recipients := append([]approval.User{instance.Applicant}, instance.Approvers...)
+
+	var failed []string
+	for _, user := range recipients {
+		if user.OpenID == "" || notified[user.OpenID] {
+			continue
+		}
+		notified[user.OpenID] = true
+
+		if optedOut, err := OptedOut(ctx, user.OpenID, user.UserID); err != nil {
+			log.Printf("读取用户 %s 的退订状态失败，仍然发送: %v", user.OpenID, err)
+		} else if optedOut {
+			log.Printf("用户 %s 已退订发版通知，跳过", user.OpenID)
+			continue
+		}
+		if err := SendUserMsg("open_id", user.OpenID, card); err != nil {
+			log.Printf("给用户 %s 发送发版通知失败: %v", user.OpenID, err)
+			failed = append(failed, user.OpenID)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("给用户 %s 发送发版通知失败", strings.Join(failed, ","))
+	}
+	return nil
+}
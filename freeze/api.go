@@ -0,0 +1,82 @@
+package freeze
+
+import (
+	"net/http"
+	"testapi/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListHandler 列出周期性冻结窗口和尚未结束的临时冻结，只返回调用方可以查看的，见 visible
+func ListHandler(c *gin.Context) {
+	windows, freezes, err := List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	p := auth.Current(c)
+	visibleWindows := make([]Window, 0, len(windows))
+	for _, w := range windows {
+		if visible(p, w.Services, w.Environments) {
+			visibleWindows = append(visibleWindows, w)
+		}
+	}
+	visibleFreezes := make([]Freeze, 0, len(freezes))
+	for _, f := range freezes {
+		if visible(p, f.Services, f.Environments) {
+			visibleFreezes = append(visibleFreezes, f)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"windows": visibleWindows,
+		"freezes": visibleFreezes,
+	})
+}
+
+// visible 调用方对冻结范围中的某个服务和环境有 viewer 权限时可以查看；
+// 冻结没有限定服务或环境时对应范围按未知检查，只有不限制这个范围的 viewer 可以查看
+func visible(p *auth.Principal, services, environments []string) bool {
+	if len(services) == 0 {
+		services = []string{""}
+	}
+	if len(environments) == 0 {
+		environments = []string{""}
+	}
+	for _, service := range services {
+		for _, environment := range environments {
+			if p.Can(auth.RoleViewer, service, environment) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CreateHandler 添加临时冻结
+func CreateHandler(c *gin.Context) {
+	var f Freeze
+	if err := c.ShouldBindJSON(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解析请求失败: " + err.Error()})
+		return
+	}
+	created, err := Add(c.Request.Context(), f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, created)
+}
+
+// DeleteHandler 提前结束临时冻结
+func DeleteHandler(c *gin.Context) {
+	removed, err := Remove(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "冻结不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,287 @@
+package freeze
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testapi/store"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+// 命中冻结时的处理方式
+const (
+	// ActionQueue 暂缓发版，冻结结束后自动执行，默认方式
+	ActionQueue = "queue"
+	// ActionReject 直接拒绝，需要重新提交审批
+	ActionReject = "reject"
+)
+
+// 临时冻结保存在存储的这个集合中，ID -> Freeze 的 JSON，所有副本共享
+const freezeKey = "release:freeze"
+
+// 计算冻结结束时间时最多向后看这么久，超过后按这个时间处理
+const maxLookahead = 31 * 24 * time.Hour
+
+// Window 周期性的冻结窗口，例如每天的业务高峰
+type Window struct {
+	Name string `json:"name"`
+	// Schedule cron 表达式(分 时 日 月 周)，匹配到的每一分钟都处于冻结中，
+	// 例如 "* 11-13 * * 1-5" 表示工作日 11:00 到 13:59
+	Schedule string `json:"schedule"`
+	// Environments/Services 冻结的环境和服务，为空表示全部，服务支持通配符
+	Environments []string `json:"environments,omitempty"`
+	Services     []string `json:"services,omitempty"`
+	// Action queue 或 reject，为空时为 queue
+	Action string `json:"action,omitempty"`
+
+	schedule schedule
+}
+
+// Freeze 临时冻结，例如节假日、故障期间，通过接口添加
+type Freeze struct {
+	ID           string    `json:"id"`
+	Reason       string    `json:"reason"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Environments []string  `json:"environments,omitempty"`
+	Services     []string  `json:"services,omitempty"`
+	Action       string    `json:"action,omitempty"`
+}
+
+// Config 冻结窗口的配置文件格式，YAML 或 JSON
+type Config struct {
+	Windows []Window `json:"windows"`
+}
+
+var (
+	mu      sync.RWMutex
+	windows []Window
+)
+
+// Parse 解析冻结窗口配置
+func Parse(data []byte) ([]Window, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("解析冻结窗口配置失败: %w", err)
+	}
+	for i := range config.Windows {
+		window := &config.Windows[i]
+		if window.Name == "" {
+			return nil, fmt.Errorf("第 %d 个冻结窗口没有名称", i+1)
+		}
+		if err := validate(window.Action, window.Services); err != nil {
+			return nil, fmt.Errorf("冻结窗口 %s: %w", window.Name, err)
+		}
+		s, err := parseSchedule(window.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("冻结窗口 %s: %w", window.Name, err)
+		}
+		window.schedule = s
+	}
+	return config.Windows, nil
+}
+
+// Load 从文件加载周期性冻结窗口
+func Load(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("读取冻结窗口配置失败: %w", err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	windows = parsed
+	return nil
+}
+
+// Add 添加临时冻结，开始时间为空时立即生效
+func Add(ctx context.Context, f Freeze) (Freeze, error) {
+	if f.Start.IsZero() {
+		f.Start = time.Now()
+	}
+	if !f.End.After(f.Start) {
+		return Freeze{}, fmt.Errorf("结束时间必须晚于开始时间")
+	}
+	if err := validate(f.Action, f.Services); err != nil {
+		return Freeze{}, err
+	}
+	if f.Action == "" {
+		f.Action = ActionQueue
+	}
+	f.ID = fmt.Sprintf("%x", time.Now().UnixNano())
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return Freeze{}, fmt.Errorf("序列化临时冻结失败: %w", err)
+	}
+	if err := store.Default().Put(ctx, freezeKey, f.ID, string(data)); err != nil {
+		return Freeze{}, fmt.Errorf("保存临时冻结失败: %w", err)
+	}
+	return f, nil
+}
+
+// Remove 删除临时冻结，不存在时返回 false
+func Remove(ctx context.Context, id string) (bool, error) {
+	_, ok, err := store.Default().Get(ctx, freezeKey, id)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, store.Default().Delete(ctx, freezeKey, id)
+}
+
+// List 返回所有周期性冻结窗口和尚未结束的临时冻结，临时冻结按开始时间排序
+func List(ctx context.Context) ([]Window, []Freeze, error) {
+	active, err := activeFreezes(ctx, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Start.Before(active[j].Start) })
+
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Window(nil), windows...), active, nil
+}
+
+// activeFreezes 从存储读取尚未结束的临时冻结，顺便删除已经结束的
+func activeFreezes(ctx context.Context, now time.Time) ([]Freeze, error) {
+	entries, err := store.Default().List(ctx, freezeKey)
+	if err != nil {
+		return nil, fmt.Errorf("读取临时冻结失败: %w", err)
+	}
+	active := make([]Freeze, 0, len(entries))
+	for id, data := range entries {
+		var f Freeze
+		if err := json.Unmarshal([]byte(data), &f); err != nil {
+			return nil, fmt.Errorf("解析临时冻结 %s 失败: %w", id, err)
+		}
+		if !f.End.After(now) {
+			if err := store.Default().Delete(ctx, freezeKey, id); err != nil {
+				log.Printf("删除已结束的临时冻结 %s 失败: %v", id, err)
+			}
+			continue
+		}
+		active = append(active, f)
+	}
+	return active, nil
+}
+
+// Hit 一次发版命中的冻结
+type Hit struct {
+	// Names 命中的冻结窗口名称或临时冻结原因
+	Names  []string
+	Action string
+	// Until 冻结结束的时间
+	Until time.Time
+}
+
+// Reason 展示在卡片上的说明
+func (h Hit) Reason() string {
+	return strings.Join(h.Names, "、")
+}
+
+// Check 检查在 now 发版到环境中的这些服务是否处于冻结中，没有冻结时返回 nil；
+// 命中的任何一个冻结要求拒绝时整体拒绝，否则暂缓到所有冻结结束。读取临时冻结失败时返回错误，调用方不应发版
+func Check(ctx context.Context, now time.Time, environment string, services []string) (*Hit, error) {
+	freezes, err := activeFreezes(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	hit := &Hit{Action: ActionQueue}
+	seen := make(map[string]bool)
+	matched := func(name, action string) {
+		if action == ActionReject {
+			hit.Action = ActionReject
+		}
+		if !seen[name] {
+			seen[name] = true
+			hit.Names = append(hit.Names, name)
+		}
+	}
+
+	// 冻结之间可能首尾相接，一直往后推到没有任何冻结为止
+	t := now
+	for t.Sub(now) < maxLookahead {
+		next := t
+		for _, window := range windows {
+			if matchTarget(window.Environments, window.Services, environment, services) && window.schedule.matches(t) {
+				matched(window.Name, window.Action)
+				if end := t.Truncate(time.Minute).Add(time.Minute); end.After(next) {
+					next = end
+				}
+			}
+		}
+		for _, f := range freezes {
+			if matchTarget(f.Environments, f.Services, environment, services) && !t.Before(f.Start) && t.Before(f.End) {
+				matched(f.Reason, f.Action)
+				if f.End.After(next) {
+					next = f.End
+				}
+			}
+		}
+		if next.Equal(t) {
+			break
+		}
+		t = next
+	}
+
+	if len(hit.Names) == 0 {
+		return nil, nil
+	}
+	hit.Until = t
+	return hit, nil
+}
+
+// matchTarget 冻结的环境和服务是否覆盖这次发版；发版没有填写环境时无法排除，按命中处理
+func matchTarget(environments, patterns []string, environment string, services []string) bool {
+	if len(environments) > 0 && environment != "" {
+		found := false
+		for _, e := range environments {
+			if e == environment {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		for _, service := range services {
+			if ok, _ := path.Match(pattern, service); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validate 检查处理方式和服务通配符
+func validate(action string, services []string) error {
+	if action != "" && action != ActionQueue && action != ActionReject {
+		return fmt.Errorf("处理方式 %s 无效，可选 %s、%s", action, ActionQueue, ActionReject)
+	}
+	for _, pattern := range services {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("服务 %s 无效: %v", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package gate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testapi/approval"
+	"testapi/freeze"
+	"testapi/k8s"
+	"testapi/policy"
+	"testapi/state"
+	"time"
+)
+
+// Check 检查审批单中的服务此时是否允许修改 Deployment：按审批单记录的审批定义、环境和审批人匹配发版策略，
+// 并且不处于发版冻结中；审批单发版、卡片上的重试和转正、失败记录重新发版在执行前都会调用，不允许时返回原因
+func Check(rel *state.Release, jobName string) error {
+	if rel == nil {
+		return fmt.Errorf("%s 没有对应的审批单发版状态，无法检查发版策略", jobName)
+	}
+	approvalCode, _, approverIDs := rel.Requester()
+	approvers := make([]approval.User, 0, len(approverIDs))
+	for _, id := range approverIDs {
+		// 发版状态中记录的是 user_id，没有 user_id 时为 open_id，策略中的审批人组两种都可以匹配
+		approvers = append(approvers, approval.User{UserID: id})
+	}
+
+	// 灰度服务按正式服务匹配策略和冻结
+	service := strings.TrimSuffix(jobName, k8s.GrayLevelSuffix)
+	violation := policy.Evaluate(policy.Request{
+		ApprovalCode: approvalCode,
+		Service:      service,
+		Environment:  rel.CurrentEnvironment(),
+		Approvers:    approvers,
+	})
+	if violation != nil {
+		return violation
+	}
+
+	// 发版冻结不区分暂缓和拒绝，命中时都不执行，冻结结束后可以重试或重新发版
+	hit, err := freeze.Check(context.TODO(), time.Now(), rel.CurrentEnvironment(), []string{service})
+	if err != nil {
+		return fmt.Errorf("无法确认 %s 是否处于发版冻结中: %w", service, err)
+	}
+	if hit != nil {
+		return fmt.Errorf("%s 命中发版冻结 [%s]，冻结到 %s", service, hit.Reason(), hit.Until.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
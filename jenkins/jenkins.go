@@ -4,6 +4,11 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"regexp"
+	"strconv"
+	"testapi/metrics"
+	"testapi/retry"
+	"time"
 
 	"github.com/bndr/gojenkins"
 )
@@ -15,40 +20,58 @@ type BuildRequest struct {
 	ChangeType         string `json:"ChangeType"`
 }
 
-// BuildHandler 函数
+// BuildHandler 函数，Jenkins 超时、5xx 时按重试策略重试
 func BuildHandler(jobName, changeType, gitlabSourceBranch string) error {
-	// 创建 HTTP 客户端
-	httpClient := &http.Client{}
+	// 创建 HTTP 客户端，单次请求最多等待 30 秒
+	httpClient := &http.Client{Timeout: 30 * time.Second, Transport: metrics.Transport(metrics.SystemJenkins, nil)}
 	// 创建一个空的上下文对象
 	ctx := context.Background()
 
-	// 创建 Jenkins 实例
+	return retry.Do(ctx, "触发 Jenkins 构建 "+jobName, func() error {
+		// 创建 Jenkins 实例
 
-	jenkins, err := gojenkins.CreateJenkins(httpClient, "http://xx.xx.xx.xx:xxx/", "xxx", "xxx").Init(ctx)
-	if err != nil {
-		log.Printf("无法初始化 Jenkins: %v", err)
-		return err
-	}
+		jenkins, err := gojenkins.CreateJenkins(httpClient, "http://xx.xx.xx.xx:xxx/", "xxx", "xxx").Init(ctx)
+		if err != nil {
+			log.Printf("无法初始化 Jenkins: %v", err)
+			return classify(err)
+		}
 
-	// 获取指定 Job 的信息
-	job, err := jenkins.GetJob(ctx, jobName)
-	if err != nil {
-		log.Printf("无法获取 Job '%s': %v", jobName, err)
-		return err
-	}
+		// 获取指定 Job 的信息
+		job, err := jenkins.GetJob(ctx, jobName)
+		if err != nil {
+			log.Printf("无法获取 Job '%s': %v", jobName, err)
+			return classify(err)
+		}
 
-	// 为指定 Job 和分支构建
-	params := map[string]string{
-		"CHANGE_TYPE":        changeType,
-		"gitlabSourceBranch": gitlabSourceBranch,
-	}
+		// 为指定 Job 和分支构建
+		params := map[string]string{
+			"CHANGE_TYPE":        changeType,
+			"gitlabSourceBranch": gitlabSourceBranch,
+		}
+
+		build, err := job.InvokeSimple(ctx, params)
+		if err != nil {
+			log.Printf("无法启动 Job '%s' 的构建: %v", jobName, err)
+			return classify(err)
+		}
 
-	build, err := job.InvokeSimple(ctx, params)
-	if err != nil {
-		log.Printf("无法启动 Job '%s' 的构建: %v", jobName, err)
+		log.Printf("Build %d for job '%s' with branch '%s' is in queue", build, jobName, gitlabSourceBranch)
+		return nil
+	})
+}
+
+// gojenkins 的部分错误只有状态码，如 "503" 或 "Invalid status code returned: 503"
+var statusCodePattern = regexp.MustCompile(`^(?:Invalid status code returned: )?(\d{3})$`)
+
+// classify 把 Jenkins 限流和 5xx 标记为可以重试，超时由 retry.IsRetryable 判断，其余错误原样返回
+func classify(err error) error {
+	m := statusCodePattern.FindStringSubmatch(err.Error())
+	if m == nil {
 		return err
 	}
-
-	log.Printf("Build %d for job '%s' with branch '%s' is in queue", build, jobName, gitlabSourceBranch)
-	return nil
+	code, _ := strconv.Atoi(m[1])
+	if code == http.StatusTooManyRequests || code >= http.StatusInternalServerError {
+		return retry.Retryable(err)
+	}
+	return err
 }
@@ -0,0 +1,201 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"testapi/registry"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// Deployment 当前版本号记录在这个注解上，每个 ReplicaSet 上也有一份
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// GrayLevelSuffix 灰度 Deployment 的名称后缀
+const GrayLevelSuffix = registry.GrayLevelSuffix
+
+// RollbackDeployment 把 Deployment 回滚到上一个版本，效果等同于 kubectl rollout undo，返回回滚前后的镜像
+func RollbackDeployment(target Target, jobName string) (ImageChange, error) {
+	service := registry.Resolve(jobName)
+	target = target.forService(service)
+	namespace := target.Namespace
+	workload := service.Workload
+	clientset, err := newClientset(target.Cluster)
+	if err != nil {
+		return ImageChange{}, err
+	}
+
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), workload, metav1.GetOptions{})
+	if err != nil {
+		return ImageChange{}, fmt.Errorf("failed to get deployment %s: %v", workload, err)
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return ImageChange{}, fmt.Errorf("invalid selector for deployment %s: %v", workload, err)
+	}
+	rsList, err := clientset.AppsV1().ReplicaSets(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return ImageChange{}, fmt.Errorf("failed to list replicasets for %s: %v", workload, err)
+	}
+
+	// 在属于这个 Deployment 的 ReplicaSet 中找到版本号小于当前版本的最大的一个
+	current, _ := strconv.ParseInt(deployment.Annotations[revisionAnnotation], 10, 64)
+	var previous *appsv1.ReplicaSet
+	var previousRevision int64
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		if !metav1.IsControlledBy(rs, deployment) {
+			continue
+		}
+		revision, err := strconv.ParseInt(rs.Annotations[revisionAnnotation], 10, 64)
+		if err != nil || revision >= current {
+			continue
+		}
+		if revision > previousRevision {
+			previous = rs
+			previousRevision = revision
+		}
+	}
+	if previous == nil {
+		return ImageChange{}, fmt.Errorf("no previous revision found for deployment %s", workload)
+	}
+
+	// pod-template-hash 由控制器维护，回滚时需要去掉
+	change := ImageChange{From: currentImages(deployment, service)}
+	template := previous.Spec.Template.DeepCopy()
+	delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+	deployment.Spec.Template = *template
+
+	if _, err := clientset.AppsV1().Deployments(namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{}); err != nil {
+		return ImageChange{From: change.From}, fmt.Errorf("failed to rollback deployment %s: %v", workload, err)
+	}
+
+	change.To = currentImages(deployment, service)
+	klog.Infof("Deployment %s rolled back to revision %d, image: %s", workload, previousRevision, FormatImages(change.To))
+	return change, nil
+}
+
+// DescribeDeploymentPods 列出 Deployment 下所有 Pod 的状态、镜像和重启次数
+func DescribeDeploymentPods(target Target, jobName string) (string, error) {
+	service := registry.Resolve(jobName)
+	target = target.forService(service)
+	namespace := target.Namespace
+	workload := service.Workload
+	clientset, err := newClientset(target.Cluster)
+	if err != nil {
+		return "", err
+	}
+
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), workload, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get deployment %s: %v", workload, err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return "", fmt.Errorf("invalid selector for deployment %s: %v", workload, err)
+	}
+
+	podList, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods: %v", err)
+	}
+	if len(podList.Items) == 0 {
+		return fmt.Sprintf("the current number of Pods for the %s is 0", workload), nil
+	}
+
+	var lines []string
+	for _, pod := range podList.Items {
+		ready := 0
+		restarts := int32(0)
+		var images []string
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			if containerStatus.Ready {
+				ready++
+			}
+			restarts += containerStatus.RestartCount
+			if service.HasContainer(containerStatus.Name) {
+				images = append(images, containerStatus.Image)
+			}
+		}
+		image := strings.Join(images, ",")
+		lines = append(lines, fmt.Sprintf("%s %s %d/%d restarts=%d %s",
+			pod.Name, pod.Status.Phase, ready, len(pod.Spec.Containers), restarts, image))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// PromoteGrayLevel 把灰度 Deployment 验证过的版本发布到正式 Deployment，返回正式 Deployment 发版前后的镜像
+func PromoteGrayLevel(target Target, jobName, versionNumber string, report ProgressFunc) (ImageChange, error) {
+	if !strings.HasSuffix(jobName, GrayLevelSuffix) {
+		return ImageChange{}, fmt.Errorf("deployment %s is not a gray-level deployment", jobName)
+	}
+	return Deploy(target, strings.TrimSuffix(jobName, GrayLevelSuffix), versionNumber, report)
+}
+
+// ClusterImages 服务在一个集群中当前的镜像
+type ClusterImages struct {
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	Workload  string `json:"workload"`
+	// Images 容器名 -> 镜像，只包含服务登记的容器
+	Images map[string]string `json:"images,omitempty"`
+	Error  string            `json:"error,omitempty"`
+}
+
+// CurrentImages 查询服务在所有已配置集群中当前的镜像，集群按名称排序；
+// namespace 为空时使用服务登记的命名空间，某个集群查询失败时记录在 Error 中
+func CurrentImages(jobName, namespace string) []ClusterImages {
+	service := registry.Resolve(jobName)
+	clusters := make([]string, 0, len(Clusters))
+	for cluster := range Clusters {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	result := make([]ClusterImages, 0, len(clusters))
+	for _, cluster := range clusters {
+		target := Target{Cluster: cluster, Namespace: namespace}.forService(service)
+		images := ClusterImages{Cluster: cluster, Namespace: target.Namespace, Workload: service.Workload}
+
+		clientset, err := newClientset(cluster)
+		if err != nil {
+			images.Error = err.Error()
+			result = append(result, images)
+			continue
+		}
+		deployment, err := clientset.AppsV1().Deployments(target.Namespace).Get(context.TODO(), service.Workload, metav1.GetOptions{})
+		if err != nil {
+			images.Error = fmt.Sprintf("failed to get deployment %s: %v", service.Workload, err)
+			result = append(result, images)
+			continue
+		}
+		images.Images = currentImages(deployment, service)
+		result = append(result, images)
+	}
+	return result
+}
+
+// RunningImages 查询服务在发版目标中当前的镜像，容器名 -> 镜像，只包含服务登记的容器
+func RunningImages(target Target, jobName string) (map[string]string, error) {
+	service := registry.Resolve(jobName)
+	target = target.forService(service)
+	clientset, err := newClientset(target.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	deployment, err := clientset.AppsV1().Deployments(target.Namespace).Get(context.TODO(), service.Workload, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deployment %s: %w", service.Workload, err)
+	}
+	return currentImages(deployment, service), nil
+}
@@ -0,0 +1,65 @@
+package k8s
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// 默认集群名称，Target 中不指定集群时使用
+const defaultCluster = "default"
+
+// Cluster 一个可以发版的集群
+type Cluster struct {
+	Name string `json:"name"`
+	// Kubeconfig 访问集群使用的 kubeconfig 文件路径
+	Kubeconfig string `json:"kubeconfig"`
+}
+
+// Clusters 集群名称 -> 集群，没有配置集群文件时只有默认集群；启动时通过 LoadClusters 加载，之后只读
+var Clusters = map[string]Cluster{
+	defaultCluster: {Name: defaultCluster, Kubeconfig: kubeconfigPath},
+}
+
+// clustersFile 集群配置文件格式，YAML 或 JSON
+type clustersFile struct {
+	Clusters []Cluster `json:"clusters"`
+}
+
+// ParseClusters 解析集群配置，返回集群名称 -> 集群
+func ParseClusters(data []byte) (map[string]Cluster, error) {
+	var f clustersFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("解析集群配置失败: %w", err)
+	}
+	clusters := make(map[string]Cluster, len(f.Clusters))
+	for i, c := range f.Clusters {
+		if c.Name == "" || c.Kubeconfig == "" {
+			return nil, fmt.Errorf("第 %d 个集群没有名称或 kubeconfig", i+1)
+		}
+		if _, ok := clusters[c.Name]; ok {
+			return nil, fmt.Errorf("集群 %s 重复配置", c.Name)
+		}
+		clusters[c.Name] = c
+	}
+	return clusters, nil
+}
+
+// LoadClusters 从文件加载集群，替换默认配置；文件中没有 default 集群时保留默认集群，
+// 发版清单和服务登记中不填写集群的服务发到默认集群
+func LoadClusters(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("读取集群配置失败: %w", err)
+	}
+	clusters, err := ParseClusters(data)
+	if err != nil {
+		return err
+	}
+	if _, ok := clusters[defaultCluster]; !ok {
+		clusters[defaultCluster] = Clusters[defaultCluster]
+	}
+	Clusters = clusters
+	return nil
+}
@@ -3,11 +3,16 @@ package k8s
 import (
 	"context"
 	"fmt"
-	"regexp"
+	"net/http"
+	"sort"
 	"strings"
+	"testapi/metrics"
+	"testapi/registry"
+	"testapi/retry"
 	sendmsg "testapi/sedmsg"
 	"time"
 
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -22,152 +27,276 @@ import (
 const (
 	kubeconfigPath = `/xxxx/xxx/.config`
 	namespace      = "xxxx"
-// 定义某些项目不走飞书审批自动
-	xxxx   = "xxxxxx"
 	mydefault      = "xxxxx"
 )
 
-// 使用正则表达式判断 jobName 是否包含任何一个常量
-func regexpString(jobName string) bool {
-	// 对常量进行转义
-	escapedConstant := regexp.QuoteMeta(intelligence)
-	//(?:^|[-])crm(?:[-]|$)
-	// 构建正则表达式模式
-	pattern := fmt.Sprintf(`(?:^|[-])%s(?:[-]|$)`, escapedConstant)
-	re := regexp.MustCompile(pattern)
-	// 检查 jobName 是否匹配
-	if match := re.FindString(jobName); match != "" {
-		return false
+// Target 发版目标，集群或命名空间为空时使用默认值
+type Target struct {
+	Cluster   string
+	Namespace string
+}
+
+// forService 未填写的集群和命名空间使用服务登记的值，再补全默认值
+func (t Target) forService(service registry.Service) Target {
+	if t.Cluster == "" {
+		t.Cluster = service.Cluster
 	}
-	return true
+	if t.Namespace == "" {
+		t.Namespace = service.Namespace
+	}
+	return t.withDefaults()
+}
+
+// ForJob 补全服务实际的发版目标，与发版、回滚时使用的集群和命名空间一致
+func (t Target) ForJob(jobName string) Target {
+	return t.forService(registry.Resolve(jobName))
 }
 
-// FeishuDeployments 更新指定 Deployment 的镜像并检查 Pod 状态
-func FeishuDeployments(jobName, versionNumber string) error {
-	if !regexpString(jobName) {
-		sendmsg.SendInteractiveMsg("智慧门店不使用这个审批流程", jobName, "red")
-		return fmt.Errorf("xxxxx项目%s不使用这个审批流程", jobName)
+// withDefaults 补全默认集群和命名空间
+func (t Target) withDefaults() Target {
+	if t.Cluster == "" {
+		t.Cluster = defaultCluster
+	}
+	if t.Namespace == "" {
+		t.Namespace = namespace
+	}
+	return t
+}
+
+// KnownCluster 集群是否已配置
+func KnownCluster(cluster string) bool {
+	_, ok := Clusters[Target{Cluster: cluster}.withDefaults().Cluster]
+	return ok
+}
+
+// newClientset 从集群对应的 kubeconfig 文件创建 Kubernetes 客户端
+func newClientset(cluster string) (*kubernetes.Clientset, error) {
+	c, ok := Clusters[cluster]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster %s", cluster)
 	}
 
 	// 从 kubeconfig 文件创建 Kubernetes 配置
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfigPath)
+	config, err := clientcmd.BuildConfigFromFlags("", c.Kubeconfig)
 	if err != nil {
-		return fmt.Errorf("failed to create config: %v", err)
+		return nil, fmt.Errorf("failed to create config: %v", err)
 	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return metrics.Transport(metrics.SystemK8s, rt)
+	})
 
 	// 创建 Kubernetes 客户端
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
+		return nil, fmt.Errorf("failed to create client: %v", err)
 	}
+	return clientset, nil
+}
 
-	// 获取指定的 Deployment
-	deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
-	if err != nil {
-		klog.Errorf("Failed to get deployment %s: %v", jobName, err)
-		return err
+// 发版进度状态，pending -> rolling -> ready/failed
+const (
+	StatusPending = "pending"
+	StatusRolling = "rolling"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+	// 通过卡片按钮回滚后的状态
+	StatusRolledBack = "rolledback"
+	// 前序发版阶段失败，没有执行
+	StatusSkipped = "skipped"
+)
+
+// ProgressFunc 发版进度回调，每当服务状态变化时调用
+type ProgressFunc func(jobName, status, detail string)
+
+// WebhookProgress 没有进度卡片时的默认回调，每次状态变化通过服务路由对应的渠道单独发一条通知，附带 links
+func WebhookProgress(versionNumber string, links ...sendmsg.Link) ProgressFunc {
+	return func(jobName, status, detail string) {
+		if status == StatusPending {
+			return
+		}
+		sendmsg.Notify(sendmsg.Event{
+			Service: jobName,
+			Version: versionNumber,
+			Status:  status,
+			Message: detail,
+			Links:   links,
+		})
 	}
+}
 
-	// 查找并更新指定容器的镜像
-	found := false
-	for i := range deployment.Spec.Template.Spec.Containers {
-		if deployment.Spec.Template.Spec.Containers[i].Name == jobName {
-			imageParts := strings.Split(deployment.Spec.Template.Spec.Containers[i].Image, ":")
-			if len(imageParts) < 2 {
-				return fmt.Errorf("invalid image name for container %s: %s", jobName, deployment.Spec.Template.Spec.Containers[i].Image)
-			}
-			deployment.Spec.Template.Spec.Containers[i].Image = fmt.Sprintf("%s:%s", imageParts[0], versionNumber)
-			found = true
-			break
+// FeishuDeployments 在默认集群和命名空间中更新指定 Deployment 的镜像并检查 Pod 状态
+func FeishuDeployments(jobName, versionNumber string, report ProgressFunc) error {
+	return DeployTo(Target{}, jobName, versionNumber, report)
+}
+
+// ImageChange 一次发版或回滚前后服务登记的容器的镜像，容器名 -> 镜像；没有修改 Deployment 时 To 为空
+type ImageChange struct {
+	From map[string]string `json:"from,omitempty"`
+	To   map[string]string `json:"to,omitempty"`
+}
+
+// FormatImages 把容器名 -> 镜像格式化为文本，只有一个容器时只有镜像，多个容器时为 "容器=镜像" 按容器名排序用逗号分隔
+func FormatImages(images map[string]string) string {
+	if len(images) == 1 {
+		for _, image := range images {
+			return image
 		}
 	}
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, name+"="+images[name])
+	}
+	return strings.Join(parts, ",")
+}
+
+// DeployTo 更新目标集群和命名空间中服务的镜像并检查 Pod 状态，report 为 nil 时通过 webhook 通知；
+// 工作负载、容器和镜像仓库通过服务登记解析，target 中未填写的集群和命名空间使用服务登记的值
+func DeployTo(target Target, jobName, versionNumber string, report ProgressFunc) error {
+	_, err := Deploy(target, jobName, versionNumber, report)
+	return err
+}
+
+// Deploy 与 DeployTo 相同，同时返回发版前后的镜像，用于审计记录
+func Deploy(target Target, jobName, versionNumber string, report ProgressFunc) (ImageChange, error) {
+	service := registry.Resolve(jobName)
+	target = target.forService(service)
+	namespace := target.Namespace
+	workload := service.Workload
+	if report == nil {
+		report = WebhookProgress(versionNumber)
+	}
 
-	if !found {
-		klog.Errorf("Container with name '%s' not found in deployment %s", jobName, jobName)
-		err := fmt.Sprintf("Container with name '%s' not found in deployment %s", jobName, jobName)
-		sendmsg.SendInteractiveMsg(err, jobName, "blue")
-		return fmt.Errorf("container with name '%s' not found in deployment %s", jobName, jobName)
+	var change ImageChange
+	clientset, err := newClientset(target.Cluster)
+	if err != nil {
+		report(jobName, StatusFailed, err.Error())
+		return change, err
 	}
 
-	// 更新 Deployment
-	_, err = clientset.AppsV1().Deployments(namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{})
+	// 获取 Deployment 并更新镜像，更新冲突或 API Server 暂时不可用时重新获取后重试
+	err = retry.Do(context.TODO(), "更新 Deployment "+workload, func() error {
+		deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), workload, metav1.GetOptions{})
+		if err != nil {
+			return classify(fmt.Errorf("Failed to get deployment %s: %w", workload, err))
+		}
+		change.From = currentImages(deployment, service)
+		change.To, err = setImages(deployment, service, versionNumber)
+		if err != nil {
+			return err
+		}
+		if _, err := clientset.AppsV1().Deployments(namespace).Update(context.TODO(), deployment, metav1.UpdateOptions{}); err != nil {
+			return classify(fmt.Errorf("Failed to update deployment %s: %w", workload, err))
+		}
+		return nil
+	})
 	if err != nil {
-		klog.Errorf("Failed to update deployment %s: %v", jobName, err)
-		errors := fmt.Sprintf("Failed to update deployment %s: %v", jobName, err)
-		sendmsg.SendInteractiveMsg(errors, jobName, "blue")
-		return err
+		klog.Errorf("%v", err)
+		report(jobName, StatusFailed, err.Error())
+		change.To = nil
+		return change, err
 	}
 
-	klog.Infof("Deployment %s updated successfully. New image: %s", jobName, versionNumber)
-	successfully := fmt.Sprintf("Deployment %s updated successfully. New image: %s", jobName, versionNumber)
-	sendmsg.SendInteractiveMsg(successfully, jobName, "green")
+	klog.Infof("Deployment %s updated successfully. New image: %s", workload, versionNumber)
+	successfully := fmt.Sprintf("Deployment %s updated successfully. New image: %s", workload, versionNumber)
+	report(jobName, StatusRolling, successfully)
 
 	// 检查 Pod 状态
-	err = CheckDeploymentPodStatusfat(clientset, jobName, versionNumber)
+	timeout := time.Duration(service.Rollout.TimeoutSeconds) * time.Second
+	err = CheckDeploymentPodStatusfat(clientset, namespace, workload, change.To, timeout)
 	if err != nil {
 		klog.Errorf("Failed to check deployment pod status: %v", err)
 		errs := fmt.Sprintf("Failed to check deployment pod status: %v", err)
-		sendmsg.SendInteractiveMsg(errs, jobName, "blue")
-		return err
+		report(jobName, StatusFailed, errs)
+		return change, err
 	}
-	sucmsg := fmt.Sprintf("successfully to check %s pod status: ok", jobName)
-	sendmsg.SendInteractiveMsg(sucmsg, jobName, "green")
-	return nil
+	sucmsg := fmt.Sprintf("successfully to check %s pod status: ok", workload)
+	report(jobName, StatusReady, sucmsg)
+	return change, nil
 }
 
-// ExtractImageAndVersion 使用正则表达式提取镜像名称和版本
-func ExtractImageAndVersion(jobName, versionNumber string) (string, string, error) {
-	// 构建完整的镜像路径
-	registry := "tastien-registry-vpc.cn-shanghai.cr.aliyuncs.com"
-	repository := "tst-uat"
+// currentImages 服务登记的容器当前的镜像
+func currentImages(deployment *appsv1.Deployment, service registry.Service) map[string]string {
+	images := make(map[string]string)
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if service.HasContainer(container.Name) {
+			images[container.Name] = container.Image
+		}
+	}
+	return images
+}
 
-	// 去掉 -gray-level 后缀
-	imageName := strings.TrimSuffix(jobName, "-gray-level")
-	fullImageName := fmt.Sprintf("%s/%s/%s", registry, repository, imageName)
-	latestImage := fmt.Sprintf("%s:%s", fullImageName, versionNumber)
+// setImages 把服务登记的容器的镜像改为新版本，返回容器名 -> 新镜像；没有登记镜像仓库时沿用当前镜像的仓库
+func setImages(deployment *appsv1.Deployment, service registry.Service, versionNumber string) (map[string]string, error) {
+	images := make(map[string]string)
+	for i := range deployment.Spec.Template.Spec.Containers {
+		container := &deployment.Spec.Template.Spec.Containers[i]
+		if !service.HasContainer(container.Name) {
+			continue
+		}
+		image := service.Image(versionNumber)
+		if image == "" {
+			repository, ok := imageRepository(container.Image)
+			if !ok {
+				return nil, fmt.Errorf("invalid image name for container %s: %s", container.Name, container.Image)
+			}
+			image = fmt.Sprintf("%s:%s", repository, versionNumber)
+		}
+		container.Image = image
+		images[container.Name] = image
+	}
 
-	return latestImage, versionNumber, nil
+	if len(images) != len(service.Containers) {
+		return nil, fmt.Errorf("containers %s not all found in deployment %s", strings.Join(service.Containers, ","), deployment.Name)
+	}
+	return images, nil
 }
 
-// ExtractImageAndVersion 使用正则表达式提取镜像名称和版本
-func ExtractImageAndVersionfat(jobName, versionNumber string) (string, string, error) {
-	imageString := fmt.Sprintf("%s:%s", jobName, versionNumber)
-	re := regexp.MustCompile(`^([a-zA-Z0-9-]+)(?:-gray-level)*:(.*)$`)
-	matches := re.FindStringSubmatch(imageString)
-	if matches == nil || len(matches) != 3 {
-		return "", "", fmt.Errorf("invalid image string format: %s", imageString)
+// imageRepository 去掉镜像的 tag，镜像没有 tag 时返回 false；仓库地址中可能带端口，只看最后一段
+func imageRepository(image string) (string, bool) {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || i < strings.LastIndex(image, "/") {
+		return "", false
 	}
-	imageName := matches[1]
-	version := matches[2]
-	imageName = strings.TrimSuffix(imageName, "-gray-level")
-	return imageName, version, nil
+	return image[:i], true
 }
 
-// CheckDeploymentPodStatus 检查 Deployment 的 Pod 状态，确保至少有一个 Pod 使用最新镜像且处于运行和就绪状态
-func CheckDeploymentPodStatusfat(clientset *kubernetes.Clientset, jobName, versionNumber string) error {
-	imageName, version, err := ExtractImageAndVersionfat(jobName, versionNumber)
-	if err != nil {
-		return err
-	}
+// 等待新版本 Pod 就绪的默认超时时间
+const defaultRolloutTimeout = 2 * time.Minute
 
-	latestImage := fmt.Sprintf("tastien-registry-vpc.cn-shanghai.cr.aliyuncs.com/%s/%s:%s", namespace, imageName, version)
-	klog.Infof("Latest Image: %s", latestImage)
+// CheckDeploymentPodStatusfat 检查 Deployment 的 Pod 状态，确保至少有一个 Pod 的容器都使用了新镜像且处于运行和就绪状态；
+// images 为容器名 -> 新镜像，timeout 为 0 时使用默认超时时间
+func CheckDeploymentPodStatusfat(clientset *kubernetes.Clientset, namespace, workload string, images map[string]string, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = defaultRolloutTimeout
+	}
+	klog.Infof("Latest Images: %v", images)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	for {
 		select {
 		case <-ctx.Done():
-			return fmt.Errorf("timeout waiting for at least one pod with the latest image to be running and ready: %s", latestImage)
+			return fmt.Errorf("timeout waiting for at least one pod with the latest image to be running and ready: %v", images)
 		default:
 			// 获取 Deployment
-			deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
+			deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), workload, metav1.GetOptions{})
 			if err != nil {
 				if errors.IsNotFound(err) {
-					return fmt.Errorf("deployment %s not found in namespace %s", jobName, namespace)
+					return fmt.Errorf("deployment %s not found in namespace %s", workload, namespace)
 				}
-				return fmt.Errorf("failed to get deployment %s: %v", jobName, err)
+				// API Server 暂时不可用时继续等待，直到超时
+				if retry.IsRetryable(classify(err)) {
+					klog.Warningf("Failed to get deployment %s, retrying: %v", workload, err)
+					time.Sleep(10 * time.Second)
+					continue
+				}
+				return fmt.Errorf("failed to get deployment %s: %v", workload, err)
 			}
 
 			// 构建 Label Selector
@@ -186,14 +315,17 @@ func CheckDeploymentPodStatusfat(clientset *kubernetes.Clientset, jobName, versi
 				LabelSelector: labelSelector,
 			})
 			if err != nil {
+				if retry.IsRetryable(classify(err)) && ctx.Err() == nil {
+					klog.Warningf("Failed to list pods for %s, retrying: %v", workload, err)
+					time.Sleep(10 * time.Second)
+					continue
+				}
 				return fmt.Errorf("failed to list pods: %v", err)
 			} else if podList == nil || len(podList.Items) == 0 {
 				time.Sleep(15 * time.Second)
 				if len(podList.Items) == 0 {
-					klog.Warningf("The current number of Pods for the %s is 0", jobName)
-					//klog.Errorf("The current number of Pods for the %s is 0", jobName)
-					//continue
-					return fmt.Errorf("the current number of Pods for the %s is 0", jobName)
+					klog.Warningf("The current number of Pods for the %s is 0", workload)
+					return fmt.Errorf("the current number of Pods for the %s is 0", workload)
 				}
 			}
 
@@ -201,7 +333,6 @@ func CheckDeploymentPodStatusfat(clientset *kubernetes.Clientset, jobName, versi
 			for _, pod := range podList.Items {
 				podName := pod.ObjectMeta.Name
 				podStatus := pod.Status.Phase
-				containerStatuses := pod.Status.ContainerStatuses
 
 				klog.Infof("Checking Pod: %s, Status: %s", podName, podStatus)
 
@@ -210,14 +341,22 @@ func CheckDeploymentPodStatusfat(clientset *kubernetes.Clientset, jobName, versi
 					continue
 				}
 
-				// 检查每个容器的状态
-				for _, containerStatus := range containerStatuses {
+				// 检查每个需要更新的容器的状态
+				matched := 0
+				for _, containerStatus := range pod.Status.ContainerStatuses {
+					image, ok := images[containerStatus.Name]
+					if !ok {
+						continue
+					}
 					klog.Infof("Checking container %s in Pod %s, Image: %s, Ready: %v", containerStatus.Name, podName, containerStatus.Image, containerStatus.Ready)
-					if containerStatus.Image == latestImage && containerStatus.State.Running != nil && containerStatus.Ready {
-						klog.Infof("Found Pod: %s with the latest image running and ready: %s", podName, latestImage)
-						return nil
+					if containerStatus.Image == image && containerStatus.State.Running != nil && containerStatus.Ready {
+						matched++
 					}
 				}
+				if matched == len(images) {
+					klog.Infof("Found Pod: %s with the latest image running and ready: %v", podName, images)
+					return nil
+				}
 			}
 
 			klog.Infof("No pod with the latest image is running and ready yet. Retrying in 10 seconds...")
@@ -226,79 +365,24 @@ func CheckDeploymentPodStatusfat(clientset *kubernetes.Clientset, jobName, versi
 	}
 }
 
-// CheckDeploymentPodStatus 检查 Deployment 的 Pod 状态，确保至少有一个 Pod 使用最新镜像且处于运行和就绪状态
-func CheckDeploymentPodStatus(clientset *kubernetes.Clientset, jobName, versionNumber string) error {
-	latestImage, _, err := ExtractImageAndVersion(jobName, versionNumber)
+// LoadServiceRegistry 从默认集群的 ConfigMap 加载服务登记配置
+func LoadServiceRegistry(namespace, name, key string) error {
+	clientset, err := newClientset(defaultCluster)
 	if err != nil {
 		return err
 	}
-
-	klog.Infof("Latest Image: %s", latestImage)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
-	defer cancel()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return fmt.Errorf("timeout waiting for at least one pod with the latest image to be running and ready: %s", latestImage)
-		default:
-			// 获取 Deployment
-			deployment, err := clientset.AppsV1().Deployments(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
-			if err != nil {
-				if errors.IsNotFound(err) {
-					return fmt.Errorf("deployment %s not found in namespace %s", jobName, namespace)
-				}
-				return fmt.Errorf("failed to get deployment %s: %v", jobName, err)
-			}
-
-			// 构建 Label Selector
-			labels := deployment.Spec.Template.ObjectMeta.Labels
-			labelSelector := ""
-			for key, value := range labels {
-				if labelSelector != "" {
-					labelSelector += ","
-				}
-				labelSelector += fmt.Sprintf("%s=%s", key, value)
-			}
-			klog.Infof("Label Selector: %s", labelSelector)
-
-			// 列出所有匹配 Label Selector 的 Pod
-			podList, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
-				LabelSelector: labelSelector,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to list pods: %v", err)
-			} else if podList == nil || len(podList.Items) == 0 {
-				klog.Warningf("The current number of Pods for the %s is 0", jobName)
-				continue
-			}
-
-			// 检查每个 Pod 的状态
-			for _, pod := range podList.Items {
-				podName := pod.ObjectMeta.Name
-				podStatus := pod.Status.Phase
-				containerStatuses := pod.Status.ContainerStatuses
-
-				klog.Infof("Checking Pod: %s, Status: %s", podName, podStatus)
-
-				if podStatus != corev1.PodRunning {
-					klog.Warningf("Pod %s is not in Running state, current state: %s", podName, podStatus)
-					continue
-				}
-
-				// 检查每个容器的状态
-				for _, containerStatus := range containerStatuses {
-					klog.Infof("Checking container %s in Pod %s, Image: %s, Ready: %v", containerStatus.Name, podName, containerStatus.Image, containerStatus.Ready)
-					if containerStatus.Image == latestImage && containerStatus.State.Running != nil && containerStatus.Ready {
-						klog.Infof("Found Pod: %s with the latest image running and ready: %s", podName, latestImage)
-						return nil
-					}
-				}
-			}
-
-			klog.Infof("No pod with the latest image is running and ready yet. Retrying in 10 seconds...")
-			time.Sleep(10 * time.Second)
-		}
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get configmap %s/%s: %v", namespace, name, err)
 	}
+	data, ok := configMap.Data[key]
+	if !ok {
+		return fmt.Errorf("configmap %s/%s has no key %s", namespace, name, key)
+	}
+	registered, err := registry.Parse([]byte(data))
+	if err != nil {
+		return err
+	}
+	registry.Set(registered)
+	return nil
 }
@@ -0,0 +1,26 @@
+package k8s
+
+import (
+	"fmt"
+	"net/http"
+	"testapi/metrics"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// LocalClientset 创建本服务所在集群的客户端，用于 Lease 选主；
+// 在 Pod 中运行时使用 ServiceAccount，否则使用默认集群的 kubeconfig
+func LocalClientset() (kubernetes.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err == rest.ErrNotInCluster {
+		return newClientset(defaultCluster)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create in-cluster config: %v", err)
+	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return metrics.Transport(metrics.SystemK8s, rt)
+	})
+	return kubernetes.NewForConfig(config)
+}
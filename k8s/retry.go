@@ -0,0 +1,21 @@
+package k8s
+
+import (
+	"testapi/retry"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// classify 把 API Server 暂时不可用、限流、超时、更新冲突和连接中断标记为可以重试，其余错误原样返回
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.IsServerTimeout(err) || errors.IsTimeout(err) || errors.IsTooManyRequests(err) ||
+		errors.IsServiceUnavailable(err) || errors.IsInternalError(err) || errors.IsConflict(err) ||
+		utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err) {
+		return retry.Retryable(err)
+	}
+	return err
+}
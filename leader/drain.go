@@ -0,0 +1,60 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrStopping 进程正在停止，不再开始新的修改 Deployment 的操作
+var ErrStopping = errors.New("进程正在停止，请稍后重试")
+
+var (
+	inflightMu sync.Mutex
+	inflight   int
+	stopping   bool
+	idle       = sync.NewCond(&inflightMu)
+)
+
+// Hold 登记一个会修改 Deployment 的操作(轮询发版、卡片操作、重新发版)，操作结束时调用返回的函数；
+// 进程正在停止时返回 ErrStopping，调用方不应开始操作
+func Hold() (func(), error) {
+	inflightMu.Lock()
+	defer inflightMu.Unlock()
+	if stopping {
+		return nil, ErrStopping
+	}
+	inflight++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			inflightMu.Lock()
+			defer inflightMu.Unlock()
+			inflight--
+			if inflight == 0 {
+				idle.Broadcast()
+			}
+		})
+	}, nil
+}
+
+// Drain 不再接受新的操作，等待已经开始的操作全部结束；ctx 先结束时返回 ctx 的错误。
+// 停止时先 Drain 再释放 Lease，避免新的主副本在旧进程还在修改 Deployment 时继续同一个审批单
+func Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		inflightMu.Lock()
+		stopping = true
+		for inflight > 0 {
+			idle.Wait()
+		}
+		inflightMu.Unlock()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
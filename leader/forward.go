@@ -0,0 +1,134 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"testapi/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 主副本的地址保存在存储的这个集合中，其他副本据此把需要在主副本上执行的请求转发过去
+const (
+	leaderKey     = "release:leader"
+	leaderAddrKey = "address"
+)
+
+// forwardedHeader 转发的请求带上转发者的标识，收到带这个头的请求时不再转发，避免两个副本互相转发
+const forwardedHeader = "X-Release-Forwarded-By"
+
+// advertised 主副本保存在存储中的地址
+type advertised struct {
+	Identity string `json:"identity"`
+	Address  string `json:"address"`
+}
+
+var (
+	// address 本副本供其他副本访问的地址，形如 http://10.0.0.1:8080
+	address atomic.Value
+	// current 当前主副本的标识，不选主时为本副本
+	current atomic.Value
+)
+
+func init() {
+	address.Store("")
+	current.Store("")
+}
+
+// defaultAddress 没有配置 LEADER_ELECTION_ADDRESS 时按 POD_IP 和 PORT 拼接本副本的地址
+func defaultAddress() string {
+	ip := os.Getenv("POD_IP")
+	if ip == "" {
+		return ""
+	}
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	return fmt.Sprintf("http://%s:%s", ip, port)
+}
+
+// advertise 成为主副本后把地址写入存储
+func advertise(ctx context.Context) {
+	addr := address.Load().(string)
+	if addr == "" {
+		log.Printf("%s 没有配置地址(LEADER_ELECTION_ADDRESS 或 POD_IP)，其他副本无法转发请求", Identity())
+		return
+	}
+	data, err := json.Marshal(advertised{Identity: Identity(), Address: addr})
+	if err != nil {
+		log.Printf("序列化主副本地址失败: %v", err)
+		return
+	}
+	if err := store.Default().Put(ctx, leaderKey, leaderAddrKey, string(data)); err != nil {
+		log.Printf("保存主副本地址失败，其他副本暂时无法转发请求: %v", err)
+	}
+}
+
+// leaderAddress 当前主副本的地址；存储中的地址属于已经卸任的主副本时返回错误
+func leaderAddress(ctx context.Context) (string, error) {
+	id := current.Load().(string)
+	if id == "" {
+		return "", fmt.Errorf("还没有选出主副本")
+	}
+	data, ok, err := store.Default().Get(ctx, leaderKey, leaderAddrKey)
+	if err != nil {
+		return "", fmt.Errorf("读取主副本地址失败: %w", err)
+	}
+	var adv advertised
+	if ok {
+		if err := json.Unmarshal([]byte(data), &adv); err != nil {
+			return "", fmt.Errorf("解析主副本地址失败: %w", err)
+		}
+	}
+	if adv.Identity != id || adv.Address == "" {
+		return "", fmt.Errorf("主副本 %s 还没有登记地址", id)
+	}
+	return adv.Address, nil
+}
+
+// Forward 只能在主副本上执行的接口使用的中间件：本副本是主副本时直接处理，否则把请求原样转发给主副本。
+// 发版队列、卡片按钮触发的发版和重新发版只在主副本上执行，这些接口转发后所有副本看到的是同一份状态
+func Forward() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if IsLeader() {
+			c.Next()
+			return
+		}
+		if by := c.GetHeader(forwardedHeader); by != "" {
+			// 转发者认为本副本是主副本，但本副本已经不是了，让调用方重试
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "主副本正在切换，请稍后重试"})
+			return
+		}
+
+		addr, err := leaderAddress(c.Request.Context())
+		if err != nil {
+			log.Printf("转发 %s %s 到主副本失败: %v", c.Request.Method, c.Request.URL.Path, err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		target, err := url.Parse(addr)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": fmt.Sprintf("主副本地址 %s 无效: %v", addr, err)})
+			return
+		}
+
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("转发 %s %s 到主副本 %s 失败: %v", r.Method, r.URL.Path, addr, err)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(gin.H{"error": "转发到主副本失败"})
+		}
+		c.Request.Header.Set(forwardedHeader, Identity())
+		proxy.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
@@ -0,0 +1,170 @@
+package leader
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"testapi/k8s"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// 选主方式
+const (
+	// BackendNone 不选主，只运行一个副本时使用
+	BackendNone = "none"
+	// BackendLease 通过 Kubernetes Lease 选主
+	BackendLease = "lease"
+)
+
+// Config 选主配置
+type Config struct {
+	// Backend none 或 lease，为空时为 none
+	Backend string
+	// Namespace/Name Lease 对象所在的命名空间和名称，同一套服务的所有副本需要一致
+	Namespace string
+	Name      string
+	// Identity 本副本的标识，为空时使用 POD_NAME 或主机名
+	Identity string
+	// Address 其他副本访问本副本的地址，本副本成为主副本后写入存储，其他副本据此转发请求；
+	// 为空时按 POD_IP 和 PORT 拼接
+	Address string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+// DefaultConfig 没有任何环境变量时的选主配置
+var DefaultConfig = Config{
+	Backend:       BackendNone,
+	Namespace:     "default",
+	Name:          "release-bot",
+	LeaseDuration: 15 * time.Second,
+	RenewDeadline: 10 * time.Second,
+	RetryPeriod:   2 * time.Second,
+}
+
+// ConfigFromEnv 从环境变量 LEADER_ELECTION、LEADER_ELECTION_NAMESPACE、LEADER_ELECTION_NAME、POD_NAME、
+// LEADER_ELECTION_ADDRESS 读取选主配置，没有设置的项使用 DefaultConfig；LEADER_ELECTION_NAMESPACE 为空时使用 POD_NAMESPACE
+func ConfigFromEnv() Config {
+	config := DefaultConfig
+	if v := os.Getenv("LEADER_ELECTION"); v != "" {
+		config.Backend = v
+	}
+	if v := os.Getenv("LEADER_ELECTION_NAMESPACE"); v != "" {
+		config.Namespace = v
+	} else if v := os.Getenv("POD_NAMESPACE"); v != "" {
+		config.Namespace = v
+	}
+	if v := os.Getenv("LEADER_ELECTION_NAME"); v != "" {
+		config.Name = v
+	}
+	config.Identity = os.Getenv("POD_NAME")
+	config.Address = os.Getenv("LEADER_ELECTION_ADDRESS")
+	return config
+}
+
+var (
+	// leading 不选主时一直为 true
+	leading  atomic.Bool
+	identity atomic.Value
+)
+
+func init() {
+	leading.Store(true)
+	identity.Store(defaultIdentity())
+}
+
+// defaultIdentity 本副本的默认标识
+func defaultIdentity() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	if host, err := os.Hostname(); err == nil {
+		return host
+	}
+	return fmt.Sprintf("pid-%d", os.Getpid())
+}
+
+// IsLeader 本副本当前是否为主，只有主副本轮询审批单和执行排期
+func IsLeader() bool {
+	return leading.Load()
+}
+
+// Identity 本副本的标识，也用作审批单认领的持有者
+func Identity() string {
+	return identity.Load().(string)
+}
+
+// Run 按配置开始选主并立即返回；失去主身份时退出进程，
+// 由新的主副本根据发版状态继续没有完成的发版，避免两个副本同时操作同一个审批单
+func Run(ctx context.Context, config Config) error {
+	if config.Identity != "" {
+		identity.Store(config.Identity)
+	}
+	if config.Address == "" {
+		config.Address = defaultAddress()
+	}
+	address.Store(config.Address)
+
+	switch config.Backend {
+	case "", BackendNone:
+		leading.Store(true)
+		current.Store(Identity())
+		return nil
+	case BackendLease:
+	default:
+		return fmt.Errorf("不支持的选主方式: %s", config.Backend)
+	}
+
+	clientset, err := k8s.LocalClientset()
+	if err != nil {
+		return err
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: config.Namespace, Name: config.Name},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: Identity()},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            config.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				log.Printf("%s 成为主副本，开始轮询审批单", Identity())
+				leading.Store(true)
+				advertise(leaderCtx)
+			},
+			OnStoppedLeading: func() {
+				leading.Store(false)
+				if ctx.Err() != nil {
+					return
+				}
+				log.Fatalf("%s 失去主副本身份，退出进程", Identity())
+			},
+			OnNewLeader: func(id string) {
+				current.Store(id)
+				if id != Identity() {
+					log.Printf("当前主副本为 %s", id)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("创建选主失败: %w", err)
+	}
+
+	leading.Store(false)
+	go elector.Run(ctx)
+	return nil
+}
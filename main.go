@@ -7,10 +7,28 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"testapi/approval"
+	"testapi/audit"
+	"testapi/auth"
+	"testapi/dingtalk"
 	"testapi/feishu"
+	"testapi/freeze"
+	"testapi/k8s"
+	"testapi/leader"
+	"testapi/metrics"
+	"testapi/pipeline"
+	"testapi/policy"
+	"testapi/queue"
+	myredis "testapi/redis"
+	"testapi/registry"
+	"testapi/retry"
+	sendmsg "testapi/sedmsg"
+	"testapi/store"
+	"testapi/wecom"
 
 	"github.com/gin-gonic/gin"
 )
@@ -24,6 +42,119 @@ func main() {
 	ticker := time.NewTicker(30 * time.Second) // 为了测试，减少间隔时间
 	defer ticker.Stop()                        // 确保在函数退出时停止 ticker
 
+	// 去重标记和发版状态的存储，配置见 store.ConfigFromEnv；使用 Redis 时连接配置见 myredis.ConfigFromEnv
+	storeConfig := store.ConfigFromEnv()
+	if storeConfig.Backend == "" || storeConfig.Backend == store.BackendRedis {
+		redisConfig, err := myredis.ConfigFromEnv()
+		if err != nil {
+			log.Fatalf("读取 Redis 配置失败: %v", err)
+		}
+		if err := myredis.Init(redisConfig); err != nil {
+			log.Fatalf("创建 Redis 客户端失败: %v", err)
+		}
+		defer myredis.Close()
+	}
+	if err := store.Init(storeConfig); err != nil {
+		log.Fatalf("打开状态存储失败: %v", err)
+	}
+	defer store.Close()
+	if err := store.Default().Ping(ctx); err != nil {
+		log.Printf("状态存储暂时不可用，恢复前不会处理审批单: %v", err)
+	}
+
+	// 可以发版的集群，CLUSTERS_FILE 不配置时只有使用内置 kubeconfig 的 default 集群
+	if path := os.Getenv("CLUSTERS_FILE"); path != "" {
+		if err := k8s.LoadClusters(path); err != nil {
+			log.Fatalf("加载集群配置失败: %v", err)
+		}
+	}
+
+	// 服务登记，SERVICE_REGISTRY_FILE 指定本地文件，SERVICE_REGISTRY_CONFIGMAP 指定 "命名空间/名称"，都不配置时按服务名 = Deployment 名 = 容器名处理
+	if path := os.Getenv("SERVICE_REGISTRY_FILE"); path != "" {
+		if err := registry.Load(path); err != nil {
+			log.Fatalf("加载服务登记失败: %v", err)
+		}
+	} else if ref := os.Getenv("SERVICE_REGISTRY_CONFIGMAP"); ref != "" {
+		namespace, name, ok := strings.Cut(ref, "/")
+		if !ok {
+			log.Fatalf("SERVICE_REGISTRY_CONFIGMAP 格式应为 命名空间/名称: %s", ref)
+		}
+		if err := k8s.LoadServiceRegistry(namespace, name, "services.yaml"); err != nil {
+			log.Fatalf("加载服务登记失败: %v", err)
+		}
+	}
+
+	// 发版策略，POLICY_FILE 不配置时使用 policy.DefaultConfig
+	if path := os.Getenv("POLICY_FILE"); path != "" {
+		if err := policy.Load(path); err != nil {
+			log.Fatalf("加载发版策略失败: %v", err)
+		}
+	}
+
+	// 飞书、Kubernetes、Jenkins 调用暂时失败时的重试策略，配置见 retry.PolicyFromEnv
+	retryPolicy, err := retry.PolicyFromEnv()
+	if err != nil {
+		log.Fatalf("读取重试配置失败: %v", err)
+	}
+	retry.SetPolicy(retryPolicy)
+
+	// 同一个服务有多个发版排队时的处理方式，wait(默认) 或 supersede
+	if p := os.Getenv("RELEASE_QUEUE_POLICY"); p != "" {
+		if err := queue.SetPolicy(p); err != nil {
+			log.Fatalf("发版队列配置错误: %v", err)
+		}
+	}
+
+	// 周期性发版冻结窗口，临时冻结通过 /freezes 接口添加
+	if path := os.Getenv("FREEZE_FILE"); path != "" {
+		if err := freeze.Load(path); err != nil {
+			log.Fatalf("加载发版冻结窗口失败: %v", err)
+		}
+	}
+
+	// 多副本时选主，只有主副本轮询审批单，配置见 leader.ConfigFromEnv；
+	// 选主使用单独的上下文，停止时等进行中的发版结束后才释放 Lease
+	leaderCtx, stopLeader := context.WithCancel(context.Background())
+	defer stopLeader()
+	if err := leader.Run(leaderCtx, leader.ConfigFromEnv()); err != nil {
+		log.Fatalf("启动选主失败: %v", err)
+	}
+
+	// 审批来源，飞书必选，配置了钉钉、企业微信审批时同时轮询
+	sources := []approval.Source{feishu.NewSource("")}
+	if dingtalk.Configured() {
+		sources = append(sources, dingtalk.NewSource())
+	}
+	if wecom.Configured() {
+		sources = append(sources, wecom.NewSource())
+	}
+	// 通过 /api/releases 发起的发版
+	sources = append(sources, pipeline.ManualSource())
+	// 发版通知中审批单详情页的地址，APPROVAL_URL_FEISHU 等，{instance} 替换为审批单号
+	for _, source := range sources {
+		if url := os.Getenv("APPROVAL_URL_" + strings.ToUpper(source.Name())); url != "" {
+			sendmsg.ApprovalURLs[source.Name()] = url
+		}
+	}
+
+	// 接口认证和角色，AUTH_FILE 配置 token、OIDC 和角色绑定；API_TOKENS 逗号分隔，拥有 admin 角色
+	if path := os.Getenv("AUTH_FILE"); path != "" {
+		if err := auth.Load(path); err != nil {
+			log.Fatalf("加载认证配置失败: %v", err)
+		}
+	}
+	auth.AddTokens(strings.Split(os.Getenv("API_TOKENS"), ","))
+	// 允许点击发版卡片按钮的飞书用户，user_id 或 open_id 逗号分隔；其他用户按 feishu: 角色绑定检查
+	if v := os.Getenv("ALLOWED_ENVIRONMENTS"); v != "" {
+		approval.SetAllowedEnvironments(strings.Split(v, ","))
+	}
+	feishu.SetCardActionAllowlist(strings.Split(os.Getenv("CARD_ACTION_ALLOWLIST"), ","))
+	// 卡片回调的 Verification Token，不配置时拒绝所有卡片回调
+	feishu.SetCardVerificationToken(os.Getenv("FEISHU_CARD_VERIFICATION_TOKEN"))
+	if os.Getenv("FEISHU_CARD_VERIFICATION_TOKEN") == "" {
+		log.Printf("没有配置 FEISHU_CARD_VERIFICATION_TOKEN，发版卡片上的按钮不可用")
+	}
+
 	// 使用 goroutine 执行定时任务
 	go func() {
 		for {
@@ -32,8 +163,8 @@ func main() {
 				fmt.Println("Task 1: Context cancelled, exiting...")
 				return
 			case <-ticker.C:
-				fmt.Println("Task 1: Calling GetInstancekubeCodeList")
-				feishu.GetInstancekubeCodeList()
+				fmt.Println("Task 1: Polling approval instances")
+				pipeline.Poll(ctx, sources)
 			}
 		}
 	}()
@@ -45,6 +176,54 @@ func main() {
 			"message": "pong",
 		})
 	})
+	// 健康检查，状态存储不可用时返回 503
+	r.GET("/healthz", func(c *gin.Context) {
+		checkCtx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+		if err := store.Default().Ping(checkCtx); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unhealthy", "store": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "ok", "store": "ok"})
+	})
+	// Prometheus 指标
+	r.GET("/metrics", metrics.Handler())
+	// 发版队列、卡片操作和重新发版只在主副本上执行，其他副本收到这些请求时转发给主副本
+	onLeader := leader.Forward()
+	// 发版卡片按钮回调
+	r.POST("/feishu/card", onLeader, feishu.CardActionHandler)
+	// 以下接口需要认证，查询需要 viewer，操作发版需要 releaser，管理冻结需要 admin
+	authed := r.Group("/", auth.Authenticate())
+	viewer := auth.Require(auth.RoleViewer)
+	releaser := auth.Require(auth.RoleReleaser)
+	admin := auth.Require(auth.RoleAdmin)
+	// 发版冻结
+	authed.GET("/freezes", viewer, freeze.ListHandler)
+	authed.POST("/freezes", admin, onLeader, freeze.CreateHandler)
+	authed.DELETE("/freezes/:id", admin, onLeader, freeze.DeleteHandler)
+	// 排期发版
+	authed.GET("/schedules", viewer, pipeline.ListSchedulesHandler)
+	authed.DELETE("/schedules/:code", releaser, onLeader, pipeline.CancelScheduleHandler)
+	// 重试后仍然失败的服务
+	authed.GET("/deadletters", viewer, pipeline.ListDeadLettersHandler)
+	authed.POST("/deadletters/:id/redrive", releaser, onLeader, pipeline.RedriveDeadLetterHandler(sources))
+	authed.DELETE("/deadletters/:id", releaser, onLeader, pipeline.DiscardDeadLetterHandler)
+	// 发版结果单聊通知的退订，用户本人或 admin 可以修改
+	authed.GET("/notify/optouts", admin, feishu.OptOutsHandler)
+	authed.PUT("/notify/optouts/:user", feishu.OptOutHandler)
+	authed.DELETE("/notify/optouts/:user", feishu.OptInHandler)
+	// 发版队列
+	authed.GET("/queue", viewer, onLeader, queue.ListHandler)
+	authed.DELETE("/queue/:id", releaser, onLeader, queue.CancelHandler)
+
+	// 发版接口，发起发版时按服务和环境检查 releaser 权限
+	api := authed.Group("/api")
+	api.POST("/releases", pipeline.SubmitReleaseHandler(sources))
+	api.GET("/releases/:code", viewer, pipeline.ReleaseStatusHandler)
+	api.GET("/services/:service/releases", viewer, pipeline.ServiceHistoryHandler)
+	api.GET("/services/:service/images", viewer, pipeline.ServiceImagesHandler)
+	api.GET("/audit", viewer, audit.ListHandler)
+	api.GET("/audit/export", viewer, audit.ExportHandler)
 
 	// 启动 HTTP 服务器
 	go func() {
@@ -68,6 +247,24 @@ func main() {
 	cancel()
 	fmt.Println("Shutting down server...")
 
-	// 等待一段时间以确保定时任务有机会停止
+	// 等进行中的轮询、卡片操作和重新发版结束后再释放 Lease，否则新的主副本可能在本进程还在修改 Deployment 时继续同一个审批单；
+	// 超时后不释放 Lease 直接退出，Lease 过期后其他副本才会接手
+	drainTimeout := 5 * time.Minute
+	if v := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			log.Fatalf("SHUTDOWN_DRAIN_TIMEOUT 格式错误: %v", err)
+		}
+		drainTimeout = d
+	}
+	drainCtx, stopDrain := context.WithTimeout(context.Background(), drainTimeout)
+	defer stopDrain()
+	if err := leader.Drain(drainCtx); err != nil {
+		log.Printf("等待进行中的发版结束超时，不释放 Lease 直接退出: %v", err)
+		os.Exit(1)
+	}
+	stopLeader()
+
+	// 等待一段时间以确保 Lease 释放完成
 	time.Sleep(5 * time.Second) // 可以根据需要调整等待时间
 }
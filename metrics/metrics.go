@@ -0,0 +1,150 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// 所有指标名的前缀
+const namespace = "release_bot"
+
+// 外部系统名称，用于接口耗时、错误和 token 刷新指标的 system 标签
+const (
+	SystemFeishu   = "feishu"
+	SystemDingTalk = "dingtalk"
+	SystemWeCom    = "wecom"
+	SystemJenkins  = "jenkins"
+	SystemK8s      = "k8s"
+)
+
+// 结果标签的取值
+const (
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+)
+
+var (
+	// PollRuns 轮询次数，不是主副本时跳过的轮询不计入
+	PollRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "poll_runs_total",
+		Help:      "审批单轮询次数",
+	})
+	// PollErrors 轮询中的错误，stage 为 list(获取审批单列表)、get(获取审批单详情)、store(读写状态存储)
+	PollErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "poll_errors_total",
+		Help:      "审批单轮询中的错误次数",
+	}, []string{"source", "stage"})
+	// ApprovalInstances 轮询时看到的还没有处理过的审批单，按审批状态统计，审批中的审批单每次轮询都会计入
+	ApprovalInstances = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "approval_instances_total",
+		Help:      "轮询到的审批单数量，按审批状态统计",
+	}, []string{"source", "status"})
+
+	// Releases 修改 Deployment 的操作次数，action 为 deploy、retry、rollback、promote
+	Releases = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "releases_total",
+		Help:      "发版次数，按服务、操作和结果统计",
+	}, []string{"service", "action", "result"})
+	// RolloutDuration 从开始修改 Deployment 到 Pod 全部就绪或失败的耗时
+	RolloutDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rollout_duration_seconds",
+		Help:      "发版耗时",
+		Buckets:   []float64{5, 15, 30, 60, 120, 180, 300, 600, 900},
+	}, []string{"service", "action", "result"})
+	// Rollbacks 回滚次数
+	Rollbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rollbacks_total",
+		Help:      "回滚次数，按服务和结果统计",
+	}, []string{"service", "result"})
+
+	// APIRequestDuration 调用外部系统接口的耗时，每次重试单独计入
+	APIRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "调用飞书、Jenkins、Kubernetes 等外部接口的耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"system", "method"})
+	// APIErrors 调用外部系统接口失败的次数；code 为 error(连接失败、超时)、HTTP 状态码(4xx、5xx)，
+	// 或者响应中的业务错误码(飞书 code、钉钉 code、企业微信 errcode)，业务错误码由各客户端解析响应后记录
+	APIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "api_errors_total",
+		Help:      "调用外部接口失败的次数",
+	}, []string{"system", "method", "code"})
+	// TokenRefreshes 获取访问 token 的次数
+	TokenRefreshes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refreshes_total",
+		Help:      "获取飞书、钉钉、企业微信访问 token 的次数",
+	}, []string{"system", "result"})
+)
+
+// Result 按错误返回结果标签
+func Result(err error) string {
+	if err != nil {
+		return ResultFailed
+	}
+	return ResultSucceeded
+}
+
+// ObserveRelease 记录一次修改 Deployment 的操作
+func ObserveRelease(service, action, result string, duration time.Duration) {
+	Releases.WithLabelValues(service, action, result).Inc()
+	RolloutDuration.WithLabelValues(service, action, result).Observe(duration.Seconds())
+	if action == "rollback" {
+		Rollbacks.WithLabelValues(service, result).Inc()
+	}
+}
+
+// TokenRefreshed 记录一次获取访问 token
+func TokenRefreshed(system string, err error) {
+	TokenRefreshes.WithLabelValues(system, Result(err)).Inc()
+}
+
+// APIError 记录一次外部接口返回的业务错误码，飞书、企业微信等出错时也返回 HTTP 200，Transport 统计不到
+func APIError(system, method, code string) {
+	APIErrors.WithLabelValues(system, method, code).Inc()
+}
+
+// Transport 记录经过 next 的每个请求的耗时和错误，next 为 nil 时使用 http.DefaultTransport
+func Transport(system string, next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &transport{system: system, next: next}
+}
+
+type transport struct {
+	system string
+	next   http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	APIRequestDuration.WithLabelValues(t.system, req.Method).Observe(time.Since(started).Seconds())
+	switch {
+	case err != nil:
+		APIErrors.WithLabelValues(t.system, req.Method, "error").Inc()
+	case resp.StatusCode >= http.StatusBadRequest:
+		APIErrors.WithLabelValues(t.system, req.Method, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
+
+// Handler Prometheus 抓取指标的接口
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
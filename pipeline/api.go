@@ -0,0 +1,163 @@
+package pipeline
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testapi/approval"
+	"testapi/auth"
+	"testapi/k8s"
+	"testapi/leader"
+	"testapi/state"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSchedulesHandler 列出排期中的发版，只返回调用方对其中每个服务和环境都有 viewer 权限的排期
+func ListSchedulesHandler(c *gin.Context) {
+	releases, err := Schedules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	p := auth.Current(c)
+	visible := make([]ScheduledRelease, 0, len(releases))
+	for _, scheduled := range releases {
+		if p.CanAll(auth.RoleViewer, scheduled.Services, scheduled.Environment) {
+			visible = append(visible, scheduled)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"schedules": visible})
+}
+
+// CancelScheduleHandler 取消审批单的排期发版，调用方需要审批单中每个服务和环境的 releaser 权限
+func CancelScheduleHandler(c *gin.Context) {
+	scheduled, err := GetSchedule(c.Param("code"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if scheduled == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "排期不存在"})
+		return
+	}
+	// 没有记录服务的排期只有不限制服务范围的 releaser 可以取消
+	if !auth.AllowedAll(c, auth.RoleReleaser, scheduled.Services, scheduled.Environment) {
+		return
+	}
+	ok, err := CancelSchedule(scheduled.InstanceCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "排期不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+// ListDeadLettersHandler 列出重试后仍然发版失败的服务，只返回调用方对服务和审批单环境有 viewer 权限的记录
+func ListDeadLettersHandler(c *gin.Context) {
+	letters, err := DeadLetters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	p := auth.Current(c)
+	environments := make(map[string]string)
+	visible := make([]DeadLetter, 0, len(letters))
+	for _, letter := range letters {
+		environment, ok := environments[letter.InstanceCode]
+		if !ok {
+			if environment, err = releaseEnvironment(letter.InstanceCode); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			environments[letter.InstanceCode] = environment
+		}
+		if p.Can(auth.RoleViewer, strings.TrimSuffix(letter.JobName, k8s.GrayLevelSuffix), environment) {
+			visible = append(visible, letter)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"deadLetters": visible})
+}
+
+// releaseEnvironment 审批单发版状态中记录的发版环境；读不到发版状态时环境未知，返回空，
+// 只有不限制环境范围的角色可以查看和操作
+func releaseEnvironment(instanceCode string) (string, error) {
+	rel, err := state.Get(instanceCode)
+	if err != nil || rel == nil {
+		return "", err
+	}
+	return rel.CurrentEnvironment(), nil
+}
+
+// deadLetterAllowed 检查调用方对失败记录中的服务和审批单环境是否有 releaser 权限；
+// 记录不存在时返回 404，不允许时返回 403，都返回 false
+func deadLetterAllowed(c *gin.Context, id string) bool {
+	letter, err := getDeadLetter(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if letter == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "失败记录不存在"})
+		return false
+	}
+	environment, err := releaseEnvironment(letter.InstanceCode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return auth.Allowed(c, auth.RoleReleaser, strings.TrimSuffix(letter.JobName, k8s.GrayLevelSuffix), environment)
+}
+
+// RedriveDeadLetterHandler 重新发版失败的服务，在后台执行，结果通过发版状态和通知查看；审计中记录调用方；
+// 调用方需要服务和环境的 releaser 权限；重新发版前用 sources 确认审批单仍然是审批通过
+func RedriveDeadLetterHandler(sources []approval.Source) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !deadLetterAllowed(c, c.Param("id")) {
+			return
+		}
+		operator := ""
+		if p := auth.Current(c); p != nil {
+			operator = p.Name
+		}
+		ok, err := RedriveDeadLetter(sources, c.Param("id"), operator)
+		if errors.Is(err, ErrRedriveDenied) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, leader.ErrStopping) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "失败记录不存在"})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{})
+	}
+}
+
+// DiscardDeadLetterHandler 丢弃失败记录，不再重新发版；调用方需要服务和环境的 releaser 权限
+func DiscardDeadLetterHandler(c *gin.Context) {
+	if !deadLetterAllowed(c, c.Param("id")) {
+		return
+	}
+	ok, err := DiscardDeadLetter(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "失败记录不存在"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
@@ -0,0 +1,228 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sort"
+	"testapi/approval"
+	"testapi/gate"
+	"testapi/k8s"
+	"testapi/leader"
+	sendmsg "testapi/sedmsg"
+	"testapi/state"
+	"testapi/store"
+	"time"
+)
+
+// 重试后仍然失败的服务保存在存储的这个集合中，ID -> DeadLetter 的 JSON
+const deadLetterKey = "release:deadletter"
+
+// DeadLetter 重试后仍然发版失败的服务，可以通过接口重新发版或丢弃
+type DeadLetter struct {
+	ID           string    `json:"id"`
+	InstanceCode string    `json:"instanceCode"`
+	JobName      string    `json:"jobName"`
+	Version      string    `json:"version"`
+	Cluster      string    `json:"cluster,omitempty"`
+	Namespace    string    `json:"namespace,omitempty"`
+	Stage        string    `json:"stage,omitempty"`
+	Error        string    `json:"error"`
+	FailedAt     time.Time `json:"failedAt"`
+	// Redrives 已经重新发版的次数
+	Redrives int `json:"redrives"`
+	// Images 失败后集群中服务的镜像，容器名 -> 镜像；重新发版前与集群当前的镜像比较，
+	// 不一致说明之后已经发布过其他版本或回滚过，不再重新发版
+	Images map[string]string `json:"images,omitempty"`
+}
+
+// deadLetterID 同一个审批单的同一个发版目标只有一条记录，再次失败时覆盖
+func deadLetterID(instanceCode string, row sendmsg.ProgressRow) string {
+	sum := sha1.Sum([]byte(instanceCode + "|" + row.JobName + "@" + row.Cluster + "/" + row.Namespace))
+	return hex.EncodeToString(sum[:8])
+}
+
+// addDeadLetter 记录发版失败的服务，保留之前重新发版的次数，保存失败时只记录日志；
+// change 为这次发版修改的镜像，没有修改 Deployment 时查询集群当前的镜像
+func addDeadLetter(rel *state.Release, row sendmsg.ProgressRow, change k8s.ImageChange, deployErr error) {
+	letter := DeadLetter{
+		ID:           deadLetterID(rel.InstanceCode, row),
+		InstanceCode: rel.InstanceCode,
+		JobName:      row.JobName,
+		Version:      row.VersionNumber,
+		Cluster:      row.Cluster,
+		Namespace:    row.Namespace,
+		Stage:        row.Stage,
+		Error:        deployErr.Error(),
+		FailedAt:     time.Now(),
+		Images:       change.To,
+	}
+	if len(letter.Images) == 0 {
+		letter.Images = change.From
+	}
+	if len(letter.Images) == 0 {
+		images, err := k8s.RunningImages(k8s.Target{Cluster: row.Cluster, Namespace: row.Namespace}, row.JobName)
+		if err != nil {
+			log.Printf("查询审批单 %s 的 %s 当前的镜像失败，失败记录不能重新发版: %v", rel.InstanceCode, row.JobName, err)
+		}
+		letter.Images = images
+	}
+	if previous, err := getDeadLetter(letter.ID); err == nil && previous != nil {
+		letter.Redrives = previous.Redrives
+	}
+	saveDeadLetter(letter)
+}
+
+func saveDeadLetter(letter DeadLetter) {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		log.Printf("序列化审批单 %s 的失败记录失败: %v", letter.InstanceCode, err)
+		return
+	}
+	if err := store.Default().Put(context.TODO(), deadLetterKey, letter.ID, string(data)); err != nil {
+		log.Printf("保存审批单 %s 的失败记录失败: %v", letter.InstanceCode, err)
+	}
+}
+
+// DeadLetters 按失败时间列出所有失败记录
+func DeadLetters() ([]DeadLetter, error) {
+	entries, err := store.Default().List(context.TODO(), deadLetterKey)
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(entries))
+	for id, data := range entries {
+		var letter DeadLetter
+		if err := json.Unmarshal([]byte(data), &letter); err != nil {
+			log.Printf("解析失败记录 %s 失败: %v", id, err)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	sort.Slice(letters, func(i, j int) bool { return letters[i].FailedAt.Before(letters[j].FailedAt) })
+	return letters, nil
+}
+
+// getDeadLetter 读取一条失败记录，不存在时返回 nil
+func getDeadLetter(id string) (*DeadLetter, error) {
+	data, ok, err := store.Default().Get(context.TODO(), deadLetterKey, id)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var letter DeadLetter
+	if err := json.Unmarshal([]byte(data), &letter); err != nil {
+		return nil, fmt.Errorf("解析失败记录 %s 失败: %w", id, err)
+	}
+	return &letter, nil
+}
+
+// DiscardDeadLetter 丢弃失败记录，不存在时返回 false
+func DiscardDeadLetter(id string) (bool, error) {
+	letter, err := getDeadLetter(id)
+	if err != nil || letter == nil {
+		return false, err
+	}
+	return true, store.Default().Delete(context.TODO(), deadLetterKey, id)
+}
+
+// ErrRedriveDenied 失败记录此时不允许重新发版，例如违反发版策略、处于发版冻结中
+var ErrRedriveDenied = errors.New("不允许重新发版")
+
+// RedriveDeadLetter 在后台重新发版失败记录中的服务，与正常发版一样检查发版策略和冻结、排队、更新发版状态和通知，
+// 审计中记录 operator；再次失败时更新记录，成功后删除记录，审批单的服务都成功时审批单改为 succeeded。
+// 审批单需要仍然是审批通过，集群中的镜像需要与失败时一致，同一条记录同时只有一个重新发版。
+// 不存在时返回 false，不允许重新发版时返回 ErrRedriveDenied，进程正在停止时返回 leader.ErrStopping
+func RedriveDeadLetter(sources []approval.Source, id, operator string) (bool, error) {
+	letter, err := getDeadLetter(id)
+	if err != nil || letter == nil {
+		return false, err
+	}
+
+	// 发版状态中记录了审批定义、环境和审批人，没有时无法检查发版策略，不重新发版
+	rel, err := state.Get(letter.InstanceCode)
+	if err != nil {
+		return false, err
+	}
+	if err := gate.Check(rel, letter.JobName); err != nil {
+		return true, fmt.Errorf("%w: %v", ErrRedriveDenied, err)
+	}
+	check := func(sendmsg.ProgressRow) error { return checkRedrive(sources, rel, letter) }
+	if err := check(sendmsg.ProgressRow{}); err != nil {
+		return true, err
+	}
+
+	// 进程停止时等重新发版结束后才释放主副本身份
+	release, err := leader.Hold()
+	if err != nil {
+		return true, err
+	}
+	// 按重新发版的次数认领，同时提交的多个请求只有一个能认领成功；认领过期前重新发版已经结束，记录的次数也已经变化
+	letter.Redrives++
+	claimed, err := store.Default().Claim(context.TODO(), fmt.Sprintf("redrive:%s#%d", letter.ID, letter.Redrives), leader.Identity(), claimTTL)
+	if err != nil {
+		release()
+		return true, fmt.Errorf("认领失败记录 %s 失败: %w", letter.ID, err)
+	}
+	if !claimed {
+		release()
+		return true, fmt.Errorf("%w: 失败记录 %s 正在重新发版", ErrRedriveDenied, letter.ID)
+	}
+	saveDeadLetter(*letter)
+
+	go func() {
+		defer release()
+		log.Printf("%s 重新发版审批单 %s 的 %s %s", operator, letter.InstanceCode, letter.JobName, letter.Version)
+		row := sendmsg.ProgressRow{
+			JobName:       letter.JobName,
+			VersionNumber: letter.Version,
+			Cluster:       letter.Cluster,
+			Namespace:     letter.Namespace,
+			Stage:         letter.Stage,
+			Status:        k8s.StatusPending,
+		}
+		// 排队期间可能有其他发版，runStage 修改 Deployment 前会再检查一次审批单、集群中的镜像、发版策略和冻结
+		if _, failed := runStage(stage{name: letter.Stage, concurrency: 1, rows: []sendmsg.ProgressRow{row}, check: check}, rel, operator, nil); failed {
+			return
+		}
+		if err := store.Default().Delete(context.TODO(), deadLetterKey, letter.ID); err != nil {
+			log.Printf("删除失败记录 %s 失败: %v", letter.ID, err)
+		}
+		if rel.AllSucceeded() {
+			rel.Transition(state.PhaseSucceeded, "")
+		}
+	}()
+	return true, nil
+}
+
+// checkRedrive 确认审批单仍然是审批通过，并且集群中服务的镜像与失败时一致；
+// 不一致说明之后已经发布过其他版本或回滚过，重新发版会覆盖它们。不允许时返回 ErrRedriveDenied
+func checkRedrive(sources []approval.Source, rel *state.Release, letter *DeadLetter) error {
+	source := findSource(sources, rel.Source)
+	if source == nil {
+		return fmt.Errorf("%w: 审批单 %s 的审批来源 %s 未启用", ErrRedriveDenied, rel.InstanceCode, rel.Source)
+	}
+	instance, err := source.GetInstance(context.TODO(), rel.InstanceCode)
+	if err != nil {
+		return fmt.Errorf("获取审批单 %s 详情失败: %w", rel.InstanceCode, err)
+	}
+	if instance.Status != approval.StatusApproved {
+		return fmt.Errorf("%w: 审批单 %s 的状态为 %s", ErrRedriveDenied, rel.InstanceCode, instance.Status)
+	}
+
+	if len(letter.Images) == 0 {
+		return fmt.Errorf("%w: 失败记录没有失败时的镜像，无法确认之后是否发布过其他版本", ErrRedriveDenied)
+	}
+	current, err := k8s.RunningImages(k8s.Target{Cluster: letter.Cluster, Namespace: letter.Namespace}, letter.JobName)
+	if err != nil {
+		return fmt.Errorf("查询 %s 当前的镜像失败: %w", letter.JobName, err)
+	}
+	if !maps.Equal(current, letter.Images) {
+		return fmt.Errorf("%w: %s 的镜像已经从 %s 变为 %s", ErrRedriveDenied, letter.JobName, k8s.FormatImages(letter.Images), k8s.FormatImages(current))
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"testapi/approval"
+	"testapi/store"
+	"time"
+)
+
+// ManualSourceName 通过接口发起的发版的审批来源名称，审批单号以 api- 开头
+const ManualSourceName = "api"
+
+// 接口发起的发版保存在存储的这个集合中，审批单号 -> manualInstance 的 JSON
+const manualKey = "release:manual"
+
+// 接口发版关联过的审批单保存在存储的这个集合中，来源/审批单号 -> 接口发版的审批单号；一个审批单只能关联一次
+const approvalRefKey = "release:approval-ref"
+
+// 接口发起的发版使用的审批定义 code 和表单映射，发版策略可以按这个 code 单独配置规则
+const manualApprovalCode = "api"
+
+var manualSchema = approval.FormSchema{
+	Table:       "发版清单",
+	Environment: "环境",
+	DeployAt:    "发版时间",
+}
+
+func init() {
+	approval.FormSchemas[manualApprovalCode] = manualSchema
+}
+
+// manualInstance 保存的接口发版
+type manualInstance struct {
+	Instance  approval.Instance `json:"instance"`
+	CreatedAt time.Time         `json:"createdAt"`
+	// Reference 关联的审批单，格式为 来源/审批单号
+	Reference string `json:"reference,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+// manualSource 把接口发起的发版当作已经审批通过的审批单，由主副本轮询时与其他审批单一样发版
+type manualSource struct{}
+
+// ManualSource 接口发版的审批来源，需要和其他审批来源一起传给 Poll
+func ManualSource() approval.Source {
+	return manualSource{}
+}
+
+func (manualSource) Name() string {
+	return ManualSourceName
+}
+
+// ListInstances 列出所有还没有处理过的接口发版，不按时间段筛选，跨天提交或者主副本停止期间提交的也不会漏掉
+func (manualSource) ListInstances(ctx context.Context, _, _ time.Time) ([]string, error) {
+	entries, err := store.Default().List(ctx, manualKey)
+	if err != nil {
+		return nil, err
+	}
+	var codes []string
+	for code := range entries {
+		processed, err := store.Default().Processed(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		if !processed {
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
+
+func (manualSource) GetInstance(ctx context.Context, code string) (*approval.Instance, error) {
+	data, ok, err := store.Default().Get(ctx, manualKey, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("接口发版 %s 不存在", code)
+	}
+	var m manualInstance
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, fmt.Errorf("解析接口发版 %s 失败: %w", code, err)
+	}
+	return &m.Instance, nil
+}
+
+// ReleaseTarget 接口发版中的一个服务，集群、命名空间和策略为空时使用服务登记的值
+type ReleaseTarget struct {
+	Service   string `json:"service"`
+	Version   string `json:"version"`
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Strategy  string `json:"strategy,omitempty"`
+}
+
+// ApprovalReference 接口发版关联的审批单，必须是审批通过的状态，发版环境一致并且包含请求中的每个服务和版本；
+// 审批人会用于发版策略检查，一个审批单只能被关联一次
+type ApprovalReference struct {
+	// Source 审批来源名称，为空时为 feishu
+	Source       string `json:"source,omitempty"`
+	InstanceCode string `json:"instanceCode"`
+}
+
+// ReleaseRequest 接口发版请求
+type ReleaseRequest struct {
+	Services []ReleaseTarget `json:"services"`
+	// Environment 发版环境，必填，必须是 approval.AllowedEnvironments 中的一个；按它检查权限、发版策略和冻结
+	Environment string             `json:"environment"`
+	DeployAt    time.Time          `json:"deployAt,omitempty"`
+	Approval    *ApprovalReference `json:"approval,omitempty"`
+	// Operator 发起人，记录为审批单的申请人；通过接口提交时为调用方
+	Operator string `json:"operator,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// form 把请求转换为接口发版表单映射对应的表单
+func (r ReleaseRequest) form() approval.Form {
+	columns := approval.DefaultTableColumns
+	table := approval.Field{Name: manualSchema.Table, Type: approval.FieldTable}
+	for _, s := range r.Services {
+		table.Rows = append(table.Rows, approval.Form{
+			{Name: columns.Service, Type: approval.FieldText, Value: s.Service},
+			{Name: columns.Version, Type: approval.FieldText, Value: s.Version},
+			{Name: columns.Cluster, Type: approval.FieldText, Value: s.Cluster},
+			{Name: columns.Namespace, Type: approval.FieldText, Value: s.Namespace},
+			{Name: columns.Strategy, Type: approval.FieldText, Value: s.Strategy},
+		})
+	}
+	form := approval.Form{
+		table,
+		{Name: manualSchema.Environment, Type: approval.FieldText, Value: r.Environment},
+	}
+	if !r.DeployAt.IsZero() {
+		form = append(form, approval.Field{Name: manualSchema.DeployAt, Type: approval.FieldDate, Start: r.DeployAt})
+	}
+	return form
+}
+
+// checkEnvironment 发版环境必须填写并且在配置的发版环境中，否则按任意取值检查权限、策略和冻结都可能绕过按环境的限制；
+// 没有配置发版环境时无法确认，拒绝接口发版
+func (r ReleaseRequest) checkEnvironment() error {
+	environment := strings.TrimSpace(r.Environment)
+	if environment == "" {
+		return fmt.Errorf("environment 不能为空")
+	}
+	if len(approval.AllowedEnvironments) == 0 {
+		return fmt.Errorf("没有配置 ALLOWED_ENVIRONMENTS，无法确认发版环境，不能接口发版")
+	}
+	for _, allowed := range approval.AllowedEnvironments {
+		if environment == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("不允许的发版环境 %q，可选 %s", environment, strings.Join(approval.AllowedEnvironments, "、"))
+}
+
+// SubmitRelease 校验接口发版请求并保存为审批通过的审批单，主副本下次轮询时发版，返回审批单号；
+// 清单校验失败时返回每一行的错误
+func SubmitRelease(ctx context.Context, sources []approval.Source, req ReleaseRequest) (string, []approval.RowError, error) {
+	if len(req.Services) == 0 {
+		return "", nil, fmt.Errorf("services 不能为空")
+	}
+	if err := req.checkEnvironment(); err != nil {
+		return "", nil, err
+	}
+	req.Environment = strings.TrimSpace(req.Environment)
+
+	instance := approval.Instance{
+		Source:       ManualSourceName,
+		ApprovalCode: manualApprovalCode,
+		Code:         "api-" + strconv.FormatInt(time.Now().UnixNano(), 36),
+		Status:       approval.StatusApproved,
+		Applicant:    approval.User{UserID: req.Operator},
+		Form:         req.form(),
+	}
+	if _, rowErrs := manualSchema.Extract(instance.Form).Validate(); len(rowErrs) > 0 {
+		return "", rowErrs, nil
+	}
+
+	m := manualInstance{Instance: instance, CreatedAt: time.Now(), Reason: req.Reason}
+	if ref := req.Approval; ref != nil {
+		name := ref.Source
+		if name == "" {
+			name = "feishu"
+		}
+		source := findSource(sources, name)
+		if source == nil {
+			return "", nil, fmt.Errorf("审批来源 %s 未启用", name)
+		}
+		referenced, err := source.GetInstance(ctx, ref.InstanceCode)
+		if err != nil {
+			return "", nil, fmt.Errorf("获取审批单 %s 失败: %w", ref.InstanceCode, err)
+		}
+		if referenced.Status != approval.StatusApproved {
+			return "", nil, fmt.Errorf("审批单 %s 当前状态为 %s，不是审批通过", ref.InstanceCode, referenced.Status)
+		}
+		if err := matchReference(referenced, req); err != nil {
+			return "", nil, err
+		}
+		m.Instance.Approvers = referenced.Approvers
+		m.Reference = name + "/" + ref.InstanceCode
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", nil, fmt.Errorf("序列化接口发版失败: %w", err)
+	}
+	// 先占用关联的审批单，同一个审批单并发提交时只有一个成功
+	if m.Reference != "" {
+		created, err := store.Default().Create(ctx, approvalRefKey, m.Reference, instance.Code)
+		if err != nil {
+			return "", nil, fmt.Errorf("记录关联的审批单失败: %w", err)
+		}
+		if !created {
+			used, _, _ := store.Default().Get(ctx, approvalRefKey, m.Reference)
+			return "", nil, fmt.Errorf("审批单 %s 已经被接口发版 %s 使用过", m.Reference, used)
+		}
+	}
+	if err := store.Default().Put(ctx, manualKey, instance.Code, string(data)); err != nil {
+		if m.Reference != "" {
+			if err := store.Default().Delete(ctx, approvalRefKey, m.Reference); err != nil {
+				log.Printf("释放关联的审批单 %s 失败: %v", m.Reference, err)
+			}
+		}
+		return "", nil, fmt.Errorf("保存接口发版失败: %w", err)
+	}
+	log.Printf("接口发版 %s 已提交，发起人 %s，关联审批单 %s", instance.Code, req.Operator, m.Reference)
+	return instance.Code, nil, nil
+}
+
+// matchReference 检查关联的审批单批准的内容覆盖这次接口发版：发版环境一致，请求中的每个服务和版本都在审批单的发版清单中
+func matchReference(referenced *approval.Instance, req ReleaseRequest) error {
+	fields := approval.SchemaFor(referenced.ApprovalCode).Extract(referenced.Form)
+	if fields.Environment != req.Environment {
+		return fmt.Errorf("审批单 %s 的发版环境为 %q，与请求的 %q 不一致", referenced.Code, fields.Environment, req.Environment)
+	}
+	items, _ := fields.Items()
+	approved := make(map[string]bool, len(items))
+	for _, item := range items {
+		approved[item.Service+" "+item.Version] = true
+	}
+	for _, target := range req.Services {
+		if !approved[strings.TrimSpace(target.Service)+" "+strings.TrimSpace(target.Version)] {
+			return fmt.Errorf("审批单 %s 的发版清单中没有 %s %s", referenced.Code, target.Service, target.Version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package pipeline
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"testapi/audit"
+	"testapi/gate"
+	"testapi/k8s"
+	"testapi/queue"
+	"testapi/registry"
+	sendmsg "testapi/sedmsg"
+	"testapi/state"
+	"time"
+)
+
+// 配置了发版阶段时，没有指定阶段的服务放在这个阶段，排在最后
+const otherStage = "其他"
+
+// stage 发版计划中的一个阶段
+type stage struct {
+	name        string
+	concurrency int
+	rows        []sendmsg.ProgressRow
+	// check 排队结束、修改 Deployment 前的额外检查，例如重新发版时确认集群中的版本没有变化；
+	// 不通过时这一行按发版失败处理，但不更新失败记录
+	check func(row sendmsg.ProgressRow) error
+}
+
+// buildPlan 按服务登记的发版阶段给发版行分组，阶段按配置顺序排列，空阶段去掉；
+// 没有配置阶段时所有服务在同一个阶段逐个发版，与原来按清单顺序发版一致
+func buildPlan(rows []sendmsg.ProgressRow) []stage {
+	stages := registry.Stages()
+	plan := make([]stage, 0, len(stages)+1)
+	index := make(map[string]int, len(stages))
+	for i, s := range stages {
+		plan = append(plan, stage{name: s.Name, concurrency: s.Concurrency})
+		index[s.Name] = i
+	}
+	last := stage{}
+	if len(stages) > 0 {
+		last.name = otherStage
+	}
+	plan = append(plan, last)
+
+	for _, row := range rows {
+		i, ok := index[registry.Resolve(row.JobName).Stage]
+		if !ok {
+			i = len(plan) - 1
+		}
+		row.Stage = plan[i].name
+		plan[i].rows = append(plan[i].rows, row)
+	}
+
+	result := plan[:0]
+	for _, s := range plan {
+		if len(s.rows) > 0 {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// runStage 执行一个阶段，阶段内按并发数同时发版；同一个服务的多行(例如多个集群)依次发版，
+// 避免进度回调更新到同名的另一行。operator 为触发发版的用户，记录在审计中，审批单自动发版时为空。
+// 返回每一行的结果，顺序与阶段内的行一致
+func runStage(s stage, rel *state.Release, operator string, report k8s.ProgressFunc) ([]string, bool) {
+	concurrency := s.concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	// 按服务名分组，保持组内顺序
+	var groups [][]int
+	groupOf := make(map[string]int)
+	for i, row := range s.rows {
+		g, ok := groupOf[row.JobName]
+		if !ok {
+			g = len(groups)
+			groupOf[row.JobName] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	outcomes := make([]string, len(s.rows))
+	var mu sync.Mutex
+	failed := false
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	for _, group := range groups {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(group []int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for _, i := range group {
+				row := s.rows[i]
+				// 重启前已经完成的服务不再发版
+				if row.Status != k8s.StatusPending {
+					mu.Lock()
+					outcomes[i] = fmt.Sprintf("%s %s: %s", row.JobName, row.VersionNumber, row.Detail)
+					mu.Unlock()
+					continue
+				}
+				target := k8s.Target{Cluster: row.Cluster, Namespace: row.Namespace}
+				progress := trackProgress(rel, row, report)
+
+				// 同一个服务同时只有一个发版，其他审批单正在发这个服务时排队
+				ticket := queue.Enqueue(queue.Entry{
+					Service:      queue.Key(row.JobName, target),
+					Version:      row.VersionNumber,
+					InstanceCode: rel.InstanceCode,
+					Environment:  rel.CurrentEnvironment(),
+					Action:       queue.ActionDeploy,
+				})
+				if ahead := ticket.Ahead(); ahead > 0 {
+					progress(row.JobName, k8s.StatusPending, fmt.Sprintf("排队中，前面还有 %d 个发版", ahead))
+				}
+				if err := ticket.Wait(); err != nil {
+					log.Printf("审批单 %s 的 %s 不再发版: %v", rel.InstanceCode, row.JobName, err)
+					progress(row.JobName, k8s.StatusSkipped, err.Error())
+					mu.Lock()
+					outcomes[i] = fmt.Sprintf("%s %s: 未执行, %v", row.JobName, row.VersionNumber, err)
+					mu.Unlock()
+					continue
+				}
+
+				if s.check != nil {
+					if err := s.check(row); err != nil {
+						ticket.Done()
+						log.Printf("审批单 %s 的 %s 不再发版: %v", rel.InstanceCode, row.JobName, err)
+						progress(row.JobName, k8s.StatusFailed, err.Error())
+						mu.Lock()
+						outcomes[i] = fmt.Sprintf("%s %s: 未执行, %v", row.JobName, row.VersionNumber, err)
+						failed = true
+						mu.Unlock()
+						continue
+					}
+				}
+
+				// 排队期间策略可能已经变化，修改 Deployment 前再检查一次，不允许时按发版失败处理
+				if err := gate.Check(rel, row.JobName); err != nil {
+					ticket.Done()
+					log.Printf("审批单 %s 的 %s 不允许发版: %v", rel.InstanceCode, row.JobName, err)
+					progress(row.JobName, k8s.StatusFailed, err.Error())
+					mu.Lock()
+					addDeadLetter(rel, row, k8s.ImageChange{}, err)
+					outcomes[i] = fmt.Sprintf("%s %s: 未执行, %v", row.JobName, row.VersionNumber, err)
+					failed = true
+					mu.Unlock()
+					continue
+				}
+
+				// 执行Kubernetes部署
+				rel.ServiceTransition(row.JobName, row.Cluster, row.Namespace, state.PhaseDeploying, "")
+				started := time.Now()
+				change, err := k8s.Deploy(target, row.JobName, row.VersionNumber, progress)
+				ticket.Done()
+				audit.Record(audit.Operation{
+					InstanceCode: rel.InstanceCode,
+					Action:       audit.ActionDeploy,
+					Operator:     operator,
+					JobName:      row.JobName,
+					Version:      row.VersionNumber,
+					Target:       target,
+					Change:       change,
+					StartedAt:    started,
+					Err:          err,
+				})
+				mu.Lock()
+				if err != nil {
+					log.Printf("执行Kubernetes部署失败: %v", err)
+					addDeadLetter(rel, row, change, err)
+					outcomes[i] = fmt.Sprintf("%s %s: 发版失败, %v", row.JobName, row.VersionNumber, err)
+					failed = true
+				} else {
+					outcomes[i] = fmt.Sprintf("%s %s: 发版成功", row.JobName, row.VersionNumber)
+				}
+				mu.Unlock()
+			}
+		}(group)
+	}
+	wg.Wait()
+	return outcomes, failed
+}
+
+// trackProgress 把发版进度同步到服务的发版状态，再交给卡片或 webhook 展示
+func trackProgress(rel *state.Release, row sendmsg.ProgressRow, report k8s.ProgressFunc) k8s.ProgressFunc {
+	if report == nil {
+		report = k8s.WebhookProgress(row.VersionNumber, sendmsg.ReleaseLinks(rel.Source, rel.InstanceCode, row.JobName)...)
+	}
+	return func(jobName, status, detail string) {
+		switch status {
+		case k8s.StatusRolling:
+			rel.ServiceTransition(row.JobName, row.Cluster, row.Namespace, state.PhaseVerifying, "")
+		case k8s.StatusReady:
+			rel.ServiceTransition(row.JobName, row.Cluster, row.Namespace, state.PhaseSucceeded, "")
+		case k8s.StatusFailed:
+			rel.ServiceTransition(row.JobName, row.Cluster, row.Namespace, state.PhaseFailed, detail)
+		case k8s.StatusSkipped:
+			rel.ServiceTransition(row.JobName, row.Cluster, row.Namespace, state.PhaseSkipped, detail)
+		}
+		report(jobName, status, detail)
+	}
+}
@@ -0,0 +1,396 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"testapi/approval"
+	"testapi/feishu"
+	"testapi/freeze"
+	"testapi/k8s"
+	"testapi/leader"
+	"testapi/metrics"
+	"testapi/policy"
+	"testapi/registry"
+	sendmsg "testapi/sedmsg"
+	"testapi/state"
+	"testapi/store"
+	"time"
+)
+
+// 审批单结束超过这个时间后不再处理
+const expireBuffer = time.Minute
+
+// 审批单认领的有效期，只需要覆盖认领到写入发版状态之间，之后由发版状态去重
+const claimTTL = 10 * time.Minute
+
+// Poll 轮询所有审批来源当天发起的审批单，审批通过的执行发版
+func Poll(ctx context.Context, sources []approval.Source) {
+	// 多副本时只有主副本轮询
+	if !leader.IsLeader() {
+		log.Printf("%s 不是主副本，跳过本次轮询", leader.Identity())
+		return
+	}
+	// 进程停止时等本次轮询中的发版结束后才释放主副本身份
+	release, err := leader.Hold()
+	if err != nil {
+		log.Printf("跳过本次轮询: %v", err)
+		return
+	}
+	defer release()
+	metrics.PollRuns.Inc()
+
+	// 获取当前日期的开始和结束时间
+	now := time.Now()
+	year, month, day := now.Date()
+	startOfDay := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	endOfDay := time.Date(year, month, day, 23, 59, 59, 0, now.Location())
+
+	// 先继续上次进程退出时没有完成的发版，再执行已经到期的排期发版
+	resumeInterrupted(ctx, sources)
+	releaseDue(ctx, sources)
+
+	for _, source := range sources {
+		instanceCodes, err := source.ListInstances(ctx, startOfDay, endOfDay)
+		if err != nil {
+			log.Printf("获取 %s 审批单列表失败: %v", source.Name(), err)
+			metrics.PollErrors.WithLabelValues(source.Name(), "list").Inc()
+			continue
+		}
+
+		// 处理每个审批实例
+		for _, instanceCode := range instanceCodes {
+			processInstance(ctx, source, instanceCode)
+		}
+	}
+}
+
+// processInstance 处理单个审批单
+func processInstance(ctx context.Context, source approval.Source, instanceCode string) {
+	fmt.Println("====================================================")
+	fmt.Println("!!!!!!!我的data", instanceCode, "test--------------")
+
+	// 检查是否已经处理过该实例
+	exists, err := store.Default().Processed(ctx, instanceCode)
+	if err != nil {
+		log.Printf("检查去重标记失败: %v", err)
+		metrics.PollErrors.WithLabelValues(source.Name(), "store").Inc()
+		return
+	}
+
+	if exists {
+		fmt.Println("这个审批已经处理过了:", instanceCode)
+		log.Printf("审批单 %s 已经处理过，跳过处理。", instanceCode)
+		return
+	}
+
+	// 已经有发版状态的审批单正在发版或者已经结束，中断的由 resumeInterrupted 继续
+	if rel, err := state.Get(instanceCode); err != nil {
+		log.Printf("检查审批单 %s 的发版状态失败: %v", instanceCode, err)
+		metrics.PollErrors.WithLabelValues(source.Name(), "store").Inc()
+		return
+	} else if rel != nil {
+		log.Printf("审批单 %s 已有发版状态 %s，跳过处理", instanceCode, rel.CurrentPhase())
+		return
+	}
+
+	// 获取审批单详情
+	instance, err := source.GetInstance(ctx, instanceCode)
+	if err != nil {
+		log.Printf("获取审批单详情失败: %v", err)
+		metrics.PollErrors.WithLabelValues(source.Name(), "get").Inc()
+		return
+	}
+	metrics.ApprovalInstances.WithLabelValues(source.Name(), instance.Status).Inc()
+
+	// 审批单已过期，跳过
+	if !instance.EndTime.IsZero() && instance.EndTime.Add(expireBuffer).Before(time.Now()) {
+		log.Printf("审批单 %s 已过期，跳过处理", instanceCode)
+		return
+	}
+
+	// 按审批定义的表单映射取出发版信息
+	fields := approval.SchemaFor(instance.ApprovalCode).Extract(instance.Form)
+
+	// 打印项目信息
+	fmt.Println("====================")
+	fmt.Println("下面打印的是审批获取到的所有信息")
+	fmt.Printf("发版内容: %s, Status: %s, 审批单实例ID: %s\n",
+		strings.Join(fields.Lines(), "; "),
+		instance.Status,
+		instanceCode)
+	fmt.Println("====================")
+
+	// 根据状态处理
+	switch instance.Status {
+	case approval.StatusApproved:
+		fmt.Println("审批单已经通过了下面开始执行发版本程序")
+		fmt.Printf("项目名称版本号: %s,  审批单状态: %s\n",
+			strings.Join(fields.Lines(), "; "),
+			instance.Status)
+
+		if !claim(ctx, instanceCode) {
+			return
+		}
+		// 读不到发版状态时不设置去重标记，认领过期后重新处理
+		if err := deploy(ctx, source, instance, fields); err != nil {
+			log.Printf("审批单 %s 暂时无法发版，稍后重试: %v", instanceCode, err)
+			metrics.PollErrors.WithLabelValues(source.Name(), "store").Inc()
+			return
+		}
+
+		// 设置去重标记
+		err = store.Default().MarkProcessed(ctx, instanceCode)
+		if err != nil {
+			log.Printf("设置去重标记失败: %v", err)
+		}
+	case approval.StatusPending:
+		fmt.Println("单子正在审批中，请耐心等待")
+	case approval.StatusRejected:
+		fmt.Println("发版被拒绝, 请找管理员确认原因")
+		if !claim(ctx, instanceCode) {
+			return
+		}
+		notifyUsers(ctx, source, instance, fmt.Sprintf("审批单 %s 被拒绝，本次不会发版，请找管理员确认原因", instanceCode), "red")
+		// 设置去重标记
+		err = store.Default().MarkProcessed(ctx, instanceCode)
+		if err != nil {
+			log.Printf("设置去重标记失败: %v", err)
+		}
+	case approval.StatusCanceled:
+		log.Printf("审批单 %s 已撤回，不会发版", instanceCode)
+		err = store.Default().MarkProcessed(ctx, instanceCode)
+		if err != nil {
+			log.Printf("设置去重标记失败: %v", err)
+		}
+	default:
+		log.Printf("未知的审批状态: %s", instance.Status)
+	}
+}
+
+// claim 认领审批单，已经被其他副本认领或者认领失败时返回 false
+func claim(ctx context.Context, instanceCode string) bool {
+	ok, err := store.Default().Claim(ctx, instanceCode, leader.Identity(), claimTTL)
+	if err != nil {
+		log.Printf("认领审批单 %s 失败: %v", instanceCode, err)
+		return false
+	}
+	if !ok {
+		log.Printf("审批单 %s 已被其他副本认领，跳过处理", instanceCode)
+	}
+	return ok
+}
+
+// deploy 按发版清单逐个发版，并把结果通知申请人和审批人；读取发版状态失败时不发版并返回错误
+func deploy(ctx context.Context, source approval.Source, instance *approval.Instance, fields approval.ReleaseFields) error {
+	instanceCode := instance.Code
+	rel, err := state.Begin(source.Name(), instanceCode)
+	if err != nil {
+		return err
+	}
+	approvers := make([]string, 0, len(instance.Approvers))
+	for _, approver := range instance.Approvers {
+		approvers = append(approvers, userID(approver))
+	}
+	// 执行前的策略检查和继续中断的发版都依赖这些状态，保存失败时不发版
+	if err := rel.SetRequester(instance.ApprovalCode, userID(instance.Applicant), approvers); err != nil {
+		return err
+	}
+	if err := rel.SetEnvironment(fields.Environment); err != nil {
+		return err
+	}
+
+	// 整个清单先校验，有任何错误都不发版，避免只发了一部分
+	items, rowErrs := fields.Validate()
+	for _, item := range items {
+		cluster := item.Cluster
+		if cluster == "" {
+			cluster = registry.Resolve(item.Service).Cluster
+		}
+		if !k8s.KnownCluster(cluster) {
+			rowErrs = append(rowErrs, approval.RowError{Row: item.Row, Raw: item.Service + " " + item.Version, Err: fmt.Sprintf("未配置的集群 %s", cluster)})
+		}
+	}
+	if len(rowErrs) > 0 {
+		rejectManifest(ctx, source, instance, rowErrs)
+		return nil
+	}
+
+	// 按发版策略检查每个服务，任何一个违反都整单拒绝；在排期之前检查，违反策略的审批单不会进入排期；
+	// 灰度服务按正式服务匹配，与执行前的检查一致
+	var violations []string
+	for _, item := range items {
+		violation := policy.Evaluate(policy.Request{
+			ApprovalCode: instance.ApprovalCode,
+			Service:      strings.TrimSuffix(item.Service, k8s.GrayLevelSuffix),
+			Environment:  fields.Environment,
+			Approvers:    instance.Approvers,
+		})
+		if violation != nil {
+			log.Printf("审批单 %s: %v", instanceCode, violation)
+			violations = append(violations, violation.Error())
+		}
+	}
+	if len(violations) > 0 {
+		rejectRelease(ctx, source, instance, fmt.Sprintf("审批单 %s 违反发版策略，本次不会发版:\n%s", instanceCode, strings.Join(violations, "\n")))
+		return nil
+	}
+
+	// 灰度服务按正式服务匹配冻结，与执行前的检查一致
+	services := make([]string, 0, len(items))
+	for _, item := range items {
+		services = append(services, strings.TrimSuffix(item.Service, k8s.GrayLevelSuffix))
+	}
+
+	// 表单中填写了计划发版时间且还没到时，放进排期，到期后再发版
+	if fields.DeployAt.After(time.Now()) {
+		at := fields.DeployAt.Format("2006-01-02 15:04")
+		log.Printf("审批单 %s 计划于 %s 发版", instanceCode, at)
+		scheduleRelease(ctx, source, instance, services, fields.Environment, fields.DeployAt, ReasonScheduled,
+			fmt.Sprintf("审批单 %s 已排期，计划发版时间 %s\n发版内容:\n%s\n如需取消请调用 DELETE /schedules/%s", instanceCode, at, strings.Join(fields.Lines(), "\n"), instanceCode))
+		return nil
+	}
+
+	// 处于发版冻结中时按冻结的处理方式暂缓或拒绝
+	hit, err := freeze.Check(ctx, time.Now(), fields.Environment, services)
+	if err != nil {
+		// 无法确认是否处于冻结中时不发版
+		log.Printf("审批单 %s 检查发版冻结失败: %v", instanceCode, err)
+		rejectRelease(ctx, source, instance, fmt.Sprintf("审批单 %s 无法确认是否处于发版冻结中，本次不会发版，请稍后重新提交: %v", instanceCode, err))
+		return nil
+	}
+	if hit != nil {
+		until := hit.Until.Format("2006-01-02 15:04")
+		if hit.Action == freeze.ActionReject {
+			rejectRelease(ctx, source, instance, fmt.Sprintf("审批单 %s 命中发版冻结 [%s]，冻结到 %s，本次不会发版，请在冻结结束后重新提交", instanceCode, hit.Reason(), until))
+			return nil
+		}
+		log.Printf("审批单 %s 命中发版冻结 [%s]，暂缓到 %s", instanceCode, hit.Reason(), until)
+		scheduleRelease(ctx, source, instance, services, fields.Environment, hit.Until, ReasonFreeze,
+			fmt.Sprintf("审批单 %s 命中发版冻结 [%s]，将在冻结结束后(%s)自动发版", instanceCode, hit.Reason(), until))
+		return nil
+	}
+
+	// 先把所有服务放进进度表，灰度策略发到对应的 -gray-level Deployment
+	var rows []sendmsg.ProgressRow
+	for _, item := range items {
+		jobName := item.Service
+		if item.Strategy == approval.StrategyGray {
+			jobName += k8s.GrayLevelSuffix
+		}
+		rows = append(rows, sendmsg.ProgressRow{
+			JobName:       jobName,
+			VersionNumber: item.Version,
+			Cluster:       item.Cluster,
+			Namespace:     item.Namespace,
+			Status:        k8s.StatusPending,
+		})
+	}
+
+	// 按发版阶段分组，卡片上的行按阶段顺序排列；重启前已经成功的服务不再发版
+	if err := rel.Transition(state.PhaseValidated, ""); err != nil {
+		return err
+	}
+	plan := buildPlan(rows)
+	rows = rows[:0]
+	for i := range plan {
+		for j := range plan[i].rows {
+			row := &plan[i].rows[j]
+			if rel.ServicePhase(row.JobName, row.Cluster, row.Namespace) == state.PhaseSucceeded {
+				row.Status = k8s.StatusReady
+				row.Detail = "重启前已完成"
+			}
+			if err := rel.Track(row.JobName, row.VersionNumber, row.Cluster, row.Namespace, row.Stage); err != nil {
+				return err
+			}
+		}
+		rows = append(rows, plan[i].rows...)
+	}
+
+	// 记录每一行的发版结果，最后单聊通知申请人和审批人
+	var outcomes []string
+	failed := false
+
+	// 整个审批单只发一张卡片，之后原地更新；没有配置群 chat_id 时回退到逐条通知
+	var report k8s.ProgressFunc
+	card := feishu.NewReleaseCard(instanceCode, append([]sendmsg.ProgressRow(nil), rows...))
+	if err := card.Start(); err != nil {
+		log.Printf("发送发版卡片失败，改用 webhook 通知: %v", err)
+	} else {
+		report = card.Update
+	}
+
+	// 阶段依次执行，前面的阶段有失败时后面的阶段全部跳过
+	if err := rel.Transition(state.PhaseDeploying, ""); err != nil {
+		return err
+	}
+	for _, s := range plan {
+		if failed {
+			for _, row := range s.rows {
+				outcomes = append(outcomes, fmt.Sprintf("%s %s: 前序阶段失败，未执行", row.JobName, row.VersionNumber))
+				rel.ServiceTransition(row.JobName, row.Cluster, row.Namespace, state.PhaseSkipped, "前序阶段失败")
+				if report != nil {
+					report(row.JobName, k8s.StatusSkipped, "前序阶段失败，未执行")
+				}
+			}
+			continue
+		}
+		if s.name != "" {
+			log.Printf("审批单 %s 开始执行发版阶段 %s", instanceCode, s.name)
+		}
+		stageOutcomes, stageFailed := runStage(s, rel, "", report)
+		outcomes = append(outcomes, stageOutcomes...)
+		failed = failed || stageFailed
+	}
+
+	colors := "green"
+	if failed {
+		colors = "red"
+		rel.Transition(state.PhaseFailed, "部分服务发版失败")
+	} else {
+		rel.Transition(state.PhaseSucceeded, "")
+	}
+	notifyUsers(ctx, source, instance, fmt.Sprintf("审批单 %s 发版结果:\n%s", instanceCode, strings.Join(outcomes, "\n")), colors)
+	return nil
+}
+
+// userID 审计和发版状态中记录的用户标识，优先 user_id
+func userID(u approval.User) string {
+	if u.UserID != "" {
+		return u.UserID
+	}
+	return u.OpenID
+}
+
+// notifyUsers 审批来源支持时，单聊通知审批单的申请人和审批人
+func notifyUsers(ctx context.Context, source approval.Source, instance *approval.Instance, message, colors string) {
+	notifier, ok := source.(approval.UserNotifier)
+	if !ok {
+		return
+	}
+	if err := notifier.NotifyUsers(ctx, instance, message, colors); err != nil {
+		log.Printf("通知审批单 %s 的用户失败: %v", instance.Code, err)
+	}
+}
+
+// rejectManifest 发版清单校验不通过，整单拒绝：列出所有错误发到群里，并单聊通知申请人和审批人
+func rejectManifest(ctx context.Context, source approval.Source, instance *approval.Instance, rowErrs []approval.RowError) {
+	lines := make([]string, 0, len(rowErrs))
+	for _, rowErr := range rowErrs {
+		log.Printf("审批单 %s 发版清单校验失败: %v", instance.Code, rowErr)
+		lines = append(lines, rowErr.Error())
+	}
+	rejectRelease(ctx, source, instance, fmt.Sprintf("审批单 %s 的发版清单有 %d 处错误，本次不会发版，请修改后重新提交:\n%s", instance.Code, len(rowErrs), strings.Join(lines, "\n")))
+}
+
+// rejectRelease 整单拒绝发版：原因发到群里，并单聊通知申请人和审批人
+func rejectRelease(ctx context.Context, source approval.Source, instance *approval.Instance, message string) {
+	if rel, err := state.Begin(source.Name(), instance.Code); err != nil {
+		log.Printf("审批单 %s 没有记录拒绝状态: %v", instance.Code, err)
+	} else {
+		rel.Transition(state.PhaseRejected, message)
+	}
+	sendmsg.SendInteractiveMsg(message, "", "red", sendmsg.ReleaseLinks(source.Name(), instance.Code, "")...)
+	notifyUsers(ctx, source, instance, message, "red")
+}
@@ -0,0 +1,168 @@
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testapi/approval"
+	"testapi/auth"
+	"testapi/k8s"
+	"testapi/state"
+	"testapi/store"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SubmitReleaseHandler 接口发版，与审批单走同一套校验、策略、冻结和发版流程，主副本下次轮询时执行；
+// 调用方需要每个服务和环境的 releaser 权限
+func SubmitReleaseHandler(sources []approval.Source) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ReleaseRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "解析请求失败: " + err.Error()})
+			return
+		}
+		// 先确认发版环境，再按它检查权限
+		if err := req.checkEnvironment(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req.Environment = strings.TrimSpace(req.Environment)
+		// 每个服务都需要 releaser 权限，发起人记录为调用方
+		for _, target := range req.Services {
+			if !auth.Allowed(c, auth.RoleReleaser, target.Service, req.Environment) {
+				return
+			}
+		}
+		if p := auth.Current(c); p != nil {
+			req.Operator = p.Name
+		}
+		code, rowErrs, err := SubmitRelease(c.Request.Context(), sources, req)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(rowErrs) > 0 {
+			errs := make([]string, 0, len(rowErrs))
+			for _, rowErr := range rowErrs {
+				errs = append(errs, rowErr.Error())
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "发版清单校验失败", "errors": errs})
+			return
+		}
+		c.JSON(http.StatusAccepted, gin.H{"instanceCode": code})
+	}
+}
+
+// ReleaseStatusHandler 查询审批单或接口发版的发版状态，调用方需要其中每个服务和发版环境的 viewer 权限
+func ReleaseStatusHandler(c *gin.Context) {
+	code := c.Param("code")
+	rel, err := state.Load(code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if rel == nil {
+		// 已经提交但还没有轮询到的接口发版，按提交的服务和环境检查
+		if _, ok, err := store.Default().Get(context.TODO(), manualKey, code); err == nil && ok {
+			instance, err := manualSource{}.GetInstance(c.Request.Context(), code)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			fields := manualSchema.Extract(instance.Form)
+			items, _ := fields.Items()
+			services := make([]string, 0, len(items))
+			for _, item := range items {
+				services = append(services, strings.TrimSuffix(item.Service, k8s.GrayLevelSuffix))
+			}
+			if !auth.AllowedAll(c, auth.RoleViewer, services, fields.Environment) {
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"instanceCode": code, "source": ManualSourceName, "phase": "queued"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "没有这个审批单的发版状态"})
+		return
+	}
+	services := rel.ServiceNames()
+	for i, service := range services {
+		services[i] = strings.TrimSuffix(service, k8s.GrayLevelSuffix)
+	}
+	if !auth.AllowedAll(c, auth.RoleViewer, services, rel.CurrentEnvironment()) {
+		return
+	}
+	data, err := rel.JSON()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// HistoryEntry 服务的一次发版记录
+type HistoryEntry struct {
+	InstanceCode string      `json:"instanceCode"`
+	Source       string      `json:"source"`
+	JobName      string      `json:"jobName"`
+	Version      string      `json:"version"`
+	Cluster      string      `json:"cluster,omitempty"`
+	Namespace    string      `json:"namespace,omitempty"`
+	Phase        state.Phase `json:"phase"`
+	Error        string      `json:"error,omitempty"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	UpdatedAt    time.Time   `json:"updatedAt"`
+}
+
+// ServiceHistoryHandler 按时间从新到旧列出服务的发版记录，包括灰度发版；limit 默认 50
+func ServiceHistoryHandler(c *gin.Context) {
+	service := c.Param("service")
+	limit := 50
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit 应为正整数"})
+			return
+		}
+		limit = n
+	}
+
+	releases, err := state.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	history := []HistoryEntry{}
+	for _, rel := range releases {
+		for _, s := range rel.Services {
+			if s.JobName != service && s.JobName != service+k8s.GrayLevelSuffix {
+				continue
+			}
+			history = append(history, HistoryEntry{
+				InstanceCode: rel.InstanceCode,
+				Source:       rel.Source,
+				JobName:      s.JobName,
+				Version:      s.Version,
+				Cluster:      s.Cluster,
+				Namespace:    s.Namespace,
+				Phase:        s.Phase,
+				Error:        s.Error,
+				CreatedAt:    rel.CreatedAt,
+				UpdatedAt:    s.UpdatedAt,
+			})
+		}
+		if len(history) >= limit {
+			history = history[:limit]
+			break
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"service": service, "releases": history})
+}
+
+// ServiceImagesHandler 查询服务在所有集群中当前的镜像，namespace 参数为空时使用服务登记的命名空间
+func ServiceImagesHandler(c *gin.Context) {
+	service := c.Param("service")
+	c.JSON(http.StatusOK, gin.H{"service": service, "clusters": k8s.CurrentImages(service, c.Query("namespace"))})
+}
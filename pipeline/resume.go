@@ -0,0 +1,41 @@
+package pipeline
+
+import (
+	"context"
+	"log"
+	"testapi/approval"
+	"testapi/state"
+)
+
+// resumeInterrupted 每次轮询时继续进程退出时没有完成的发版，已经成功的服务不会重新发版；
+// 轮询是串行的，执行到这里时本进程没有正在进行的审批单发版，没有结束的都是中断的。
+// 读取发版状态或审批单失败时保留原状态，下次轮询重试，只有审批单不再是审批通过时才结束
+func resumeInterrupted(ctx context.Context, sources []approval.Source) {
+	releases, err := state.Interrupted()
+	if err != nil {
+		log.Printf("读取未完成的发版失败: %v", err)
+		return
+	}
+
+	for _, rel := range releases {
+		source := findSource(sources, rel.Source)
+		if source == nil {
+			log.Printf("审批单 %s 的审批来源 %s 未启用，无法继续发版", rel.InstanceCode, rel.Source)
+			continue
+		}
+		instance, err := source.GetInstance(ctx, rel.InstanceCode)
+		if err != nil {
+			log.Printf("获取审批单 %s 详情失败，下次轮询继续发版: %v", rel.InstanceCode, err)
+			continue
+		}
+		if instance.Status != approval.StatusApproved {
+			rel.Transition(state.PhaseRejected, "审批单状态为 "+instance.Status)
+			continue
+		}
+
+		log.Printf("审批单 %s 上次发版停在 %s，继续发版", rel.InstanceCode, rel.CurrentPhase())
+		if err := deploy(ctx, source, instance, approval.SchemaFor(instance.ApprovalCode).Extract(instance.Form)); err != nil {
+			log.Printf("审批单 %s 暂时无法继续发版，下次轮询重试: %v", rel.InstanceCode, err)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"testapi/approval"
+	sendmsg "testapi/sedmsg"
+	"testapi/state"
+	"testapi/store"
+	"time"
+)
+
+// 排期保存在存储的这个集合中，审批单号 -> ScheduledRelease 的 JSON，所有副本共享，重启后继续执行
+const scheduleKey = "release:schedule"
+
+// 排期原因
+const (
+	// ReasonScheduled 表单中填写了计划发版时间
+	ReasonScheduled = "scheduled"
+	// ReasonFreeze 命中发版冻结，冻结结束后发版
+	ReasonFreeze = "freeze"
+)
+
+// ScheduledRelease 排期中的发版；到期后重新获取审批单，仍然是审批通过状态才发版
+type ScheduledRelease struct {
+	Source       string    `json:"source"`
+	InstanceCode string    `json:"instanceCode"`
+	At           time.Time `json:"at"`
+	Reason       string    `json:"reason"`
+	// Detail 排期说明，例如命中的冻结名称
+	Detail string `json:"detail,omitempty"`
+	// Services/Environment 审批单中的服务和发版环境，取消排期时按它们检查权限
+	Services    []string `json:"services,omitempty"`
+	Environment string   `json:"environment,omitempty"`
+}
+
+// scheduleMu 串行执行排期的添加、取消和到期，排期本身只保存在存储中，每次都从存储读取
+var scheduleMu sync.Mutex
+
+// listSchedule 从存储读取所有排期
+func listSchedule(ctx context.Context) ([]ScheduledRelease, error) {
+	entries, err := store.Default().List(ctx, scheduleKey)
+	if err != nil {
+		return nil, fmt.Errorf("读取排期失败: %w", err)
+	}
+	releases := make([]ScheduledRelease, 0, len(entries))
+	for code, data := range entries {
+		var release ScheduledRelease
+		if err := json.Unmarshal([]byte(data), &release); err != nil {
+			log.Printf("解析审批单 %s 的排期失败: %v", code, err)
+			continue
+		}
+		releases = append(releases, release)
+	}
+	return releases, nil
+}
+
+// addSchedule 添加或替换排期
+func addSchedule(ctx context.Context, release ScheduledRelease) error {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+
+	data, err := json.Marshal(release)
+	if err != nil {
+		return fmt.Errorf("序列化审批单 %s 的排期失败: %w", release.InstanceCode, err)
+	}
+	if err := store.Default().Put(ctx, scheduleKey, release.InstanceCode, string(data)); err != nil {
+		return fmt.Errorf("保存审批单 %s 的排期失败: %w", release.InstanceCode, err)
+	}
+	return nil
+}
+
+// Schedules 按发版时间列出所有排期
+func Schedules() ([]ScheduledRelease, error) {
+	releases, err := listSchedule(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].At.Before(releases[j].At) })
+	return releases, nil
+}
+
+// GetSchedule 读取审批单的排期，不存在时返回 nil
+func GetSchedule(code string) (*ScheduledRelease, error) {
+	data, ok, err := store.Default().Get(context.TODO(), scheduleKey, code)
+	if err != nil || !ok {
+		return nil, err
+	}
+	var release ScheduledRelease
+	if err := json.Unmarshal([]byte(data), &release); err != nil {
+		return nil, fmt.Errorf("解析审批单 %s 的排期失败: %w", code, err)
+	}
+	return &release, nil
+}
+
+// CancelSchedule 取消排期，排期不存在时返回 false
+func CancelSchedule(code string) (bool, error) {
+	scheduleMu.Lock()
+	defer scheduleMu.Unlock()
+	scheduled, err := GetSchedule(code)
+	if err != nil || scheduled == nil {
+		return false, err
+	}
+	if err := store.Default().Delete(context.TODO(), scheduleKey, code); err != nil {
+		return false, fmt.Errorf("删除审批单 %s 的排期失败: %w", code, err)
+	}
+	if rel, err := state.Get(code); err == nil && rel != nil {
+		rel.Transition(state.PhaseRejected, "排期发版已取消")
+	}
+	sendmsg.SendInteractiveMsg(fmt.Sprintf("审批单 %s 的排期发版已取消", code), "", "orange", sendmsg.ReleaseLinks(scheduled.Source, code, "")...)
+	return true, nil
+}
+
+// scheduleRelease 把审批单放进排期并发卡片说明发版时间
+func scheduleRelease(ctx context.Context, source approval.Source, instance *approval.Instance, services []string, environment string, at time.Time, reason, message string) {
+	err := addSchedule(ctx, ScheduledRelease{
+		Source:       source.Name(),
+		InstanceCode: instance.Code,
+		At:           at,
+		Reason:       reason,
+		Detail:       message,
+		Services:     services,
+		Environment:  environment,
+	})
+	if err != nil {
+		// 排期只保存在存储中，保存失败时无法按时发版，整单拒绝
+		log.Printf("审批单 %s 放进排期失败: %v", instance.Code, err)
+		rejectRelease(ctx, source, instance, fmt.Sprintf("审批单 %s 放进排期失败，本次不会发版，请稍后重新提交: %v", instance.Code, err))
+		return
+	}
+	if rel, err := state.Begin(source.Name(), instance.Code); err != nil {
+		log.Printf("审批单 %s 没有记录排期状态: %v", instance.Code, err)
+	} else {
+		rel.Transition(state.PhaseScheduled, "")
+	}
+	sendmsg.SendInteractiveMsg(message, "", "orange", sendmsg.ReleaseLinks(source.Name(), instance.Code, "")...)
+	notifyUsers(ctx, source, instance, message, "orange")
+}
+
+// releaseDue 执行已经到期的排期；到期后重新获取审批单，发版前仍然会检查策略和冻结
+func releaseDue(ctx context.Context, sources []approval.Source) {
+	now := time.Now()
+	var due []ScheduledRelease
+	scheduleMu.Lock()
+	releases, err := listSchedule(ctx)
+	if err != nil {
+		log.Printf("加载排期失败: %v", err)
+	}
+	for _, release := range releases {
+		if release.At.After(now) {
+			continue
+		}
+		// 删除失败时留到下次执行，避免同一个排期发两次
+		if err := store.Default().Delete(ctx, scheduleKey, release.InstanceCode); err != nil {
+			log.Printf("删除审批单 %s 的排期失败，稍后重试: %v", release.InstanceCode, err)
+			continue
+		}
+		due = append(due, release)
+	}
+	scheduleMu.Unlock()
+
+	for _, release := range due {
+		source := findSource(sources, release.Source)
+		if source == nil {
+			log.Printf("审批单 %s 的审批来源 %s 未启用，跳过排期", release.InstanceCode, release.Source)
+			continue
+		}
+		instance, err := source.GetInstance(ctx, release.InstanceCode)
+		if err != nil {
+			log.Printf("获取审批单 %s 详情失败，稍后重试: %v", release.InstanceCode, err)
+			if err := addSchedule(ctx, release); err != nil {
+				log.Printf("审批单 %s 的排期丢失: %v", release.InstanceCode, err)
+			}
+			continue
+		}
+		if instance.Status != approval.StatusApproved {
+			log.Printf("审批单 %s 当前状态为 %s，取消排期发版", release.InstanceCode, instance.Status)
+			if rel, err := state.Begin(source.Name(), instance.Code); err != nil {
+				log.Printf("审批单 %s 没有记录拒绝状态: %v", instance.Code, err)
+			} else {
+				rel.Transition(state.PhaseRejected, "审批单状态为 "+instance.Status)
+			}
+			continue
+		}
+
+		log.Printf("审批单 %s 排期到期，开始发版", release.InstanceCode)
+		if err := deploy(ctx, source, instance, approval.SchemaFor(instance.ApprovalCode).Extract(instance.Form)); err != nil {
+			log.Printf("审批单 %s 暂时无法发版，放回排期稍后重试: %v", release.InstanceCode, err)
+			if err := addSchedule(ctx, release); err != nil {
+				log.Printf("审批单 %s 的排期丢失: %v", release.InstanceCode, err)
+			}
+		}
+	}
+}
+
+// findSource 按名称查找审批来源
+func findSource(sources []approval.Source, name string) approval.Source {
+	for _, source := range sources {
+		if source.Name() == name {
+			return source
+		}
+	}
+	return nil
+}
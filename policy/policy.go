@@ -0,0 +1,230 @@
+package policy
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testapi/approval"
+
+	"sigs.k8s.io/yaml"
+)
+
+// 规则的效果
+const (
+	EffectAllow = "allow"
+	EffectDeny  = "deny"
+)
+
+// Rule 一条发版策略；条件为空表示匹配任意值，服务支持 path.Match 通配符
+type Rule struct {
+	// Name 规则名称，拒绝发版时展示在卡片上
+	Name string `json:"name"`
+	// ApprovalCodes 审批定义 code(飞书 approval_code、钉钉 processCode、企业微信 template_id)
+	ApprovalCodes []string `json:"approvalCodes,omitempty"`
+	Services      []string `json:"services,omitempty"`
+	Environments  []string `json:"environments,omitempty"`
+	// Effect allow 或 deny，为空时为 allow
+	Effect string `json:"effect,omitempty"`
+	// RequiredApprovers allow 时要求每个审批人组中至少有一人参与审批
+	RequiredApprovers []string `json:"requiredApprovers,omitempty"`
+	// Reason 拒绝时附带的说明
+	Reason string `json:"reason,omitempty"`
+}
+
+// matches 规则的条件是否匹配这次发版
+func (r Rule) matches(req Request) bool {
+	if len(r.ApprovalCodes) > 0 && !contains(r.ApprovalCodes, req.ApprovalCode) {
+		return false
+	}
+	if len(r.Environments) > 0 && !contains(r.Environments, req.Environment) {
+		return false
+	}
+	if len(r.Services) == 0 {
+		return true
+	}
+	for _, pattern := range r.Services {
+		if ok, _ := path.Match(pattern, req.Service); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Config 发版策略的配置文件格式，YAML 或 JSON
+type Config struct {
+	// Rules 按顺序匹配，第一条匹配的规则生效
+	Rules []Rule `json:"rules"`
+	// Groups 审批人组名 -> 组内用户的 user_id 或 open_id
+	Groups map[string][]string `json:"groups,omitempty"`
+	// DefaultEffect 没有规则匹配时的效果，为空时为 allow
+	DefaultEffect string `json:"defaultEffect,omitempty"`
+}
+
+// DefaultConfig 没有配置策略文件时使用的策略
+var DefaultConfig = Config{
+	Rules: []Rule{
+		{
+			// 定义某些项目不走飞书审批自动发版
+			Name:     "智慧门店不使用这个审批流程",
+			Services: []string{"xxxxxx", "xxxxxx-*", "*-xxxxxx", "*-xxxxxx-*"},
+			Effect:   EffectDeny,
+		},
+	},
+}
+
+var (
+	mu     sync.RWMutex
+	config = DefaultConfig
+)
+
+// Parse 解析发版策略配置，YAML 和 JSON 都可以
+func Parse(data []byte) (Config, error) {
+	var c Config
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return Config{}, fmt.Errorf("解析发版策略配置失败: %w", err)
+	}
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return Config{}, fmt.Errorf("第 %d 条发版策略没有名称", i+1)
+		}
+		if rule.Effect != "" && rule.Effect != EffectAllow && rule.Effect != EffectDeny {
+			return Config{}, fmt.Errorf("发版策略 %s 的效果 %s 无效", rule.Name, rule.Effect)
+		}
+		for _, pattern := range rule.Services {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return Config{}, fmt.Errorf("发版策略 %s 的服务 %s 无效: %v", rule.Name, pattern, err)
+			}
+		}
+		for _, group := range rule.RequiredApprovers {
+			if _, ok := c.Groups[group]; !ok {
+				return Config{}, fmt.Errorf("发版策略 %s 的审批人组 %s 没有定义", rule.Name, group)
+			}
+		}
+	}
+	if c.DefaultEffect != "" && c.DefaultEffect != EffectAllow && c.DefaultEffect != EffectDeny {
+		return Config{}, fmt.Errorf("默认效果 %s 无效", c.DefaultEffect)
+	}
+	return c, nil
+}
+
+// Set 替换当前的发版策略
+func Set(c Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	config = c
+}
+
+// Load 从文件加载发版策略
+func Load(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("读取发版策略配置失败: %w", err)
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	Set(c)
+	return nil
+}
+
+// Request 一次待检查的发版
+type Request struct {
+	ApprovalCode string
+	Service      string
+	Environment  string
+	Approvers    []approval.User
+}
+
+// Violation 违反的规则
+type Violation struct {
+	Rule    string
+	Service string
+	Reason  string
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("%s 违反发版策略 [%s]: %s", v.Service, v.Rule, v.Reason)
+}
+
+// Evaluate 按顺序匹配规则，返回违反的规则，允许发版时返回 nil；
+// 没有填写环境时无法排除任何环境，按规则中出现的每个环境分别检查，有一个不允许就不允许
+func Evaluate(req Request) *Violation {
+	mu.RLock()
+	c := config
+	mu.RUnlock()
+
+	if req.Environment != "" {
+		return evaluate(c, req)
+	}
+	if violation := evaluate(c, req); violation != nil {
+		return violation
+	}
+	seen := map[string]bool{}
+	for _, rule := range c.Rules {
+		for _, environment := range rule.Environments {
+			environment = strings.TrimSpace(environment)
+			if seen[environment] {
+				continue
+			}
+			seen[environment] = true
+			envReq := req
+			envReq.Environment = environment
+			if violation := evaluate(c, envReq); violation != nil {
+				violation.Reason = fmt.Sprintf("没有填写发版环境，按环境 %s 检查: %s", environment, violation.Reason)
+				return violation
+			}
+		}
+	}
+	return nil
+}
+
+// evaluate 按环境匹配规则
+func evaluate(c Config, req Request) *Violation {
+	for _, rule := range c.Rules {
+		if !rule.matches(req) {
+			continue
+		}
+		if rule.Effect == EffectDeny {
+			reason := rule.Reason
+			if reason == "" {
+				reason = "不允许通过这个审批流程发版"
+			}
+			return &Violation{Rule: rule.Name, Service: req.Service, Reason: reason}
+		}
+		for _, group := range rule.RequiredApprovers {
+			if !approvedBy(c.Groups[group], req.Approvers) {
+				return &Violation{Rule: rule.Name, Service: req.Service, Reason: fmt.Sprintf("需要审批人组 %s 中至少一人审批", group)}
+			}
+		}
+		return nil
+	}
+
+	if c.DefaultEffect == EffectDeny {
+		return &Violation{Rule: "默认策略", Service: req.Service, Reason: "没有匹配的发版策略"}
+	}
+	return nil
+}
+
+// approvedBy 审批人中是否有人属于组内
+func approvedBy(members []string, approvers []approval.User) bool {
+	for _, approver := range approvers {
+		if (approver.UserID != "" && contains(members, approver.UserID)) ||
+			(approver.OpenID != "" && contains(members, approver.OpenID)) {
+			return true
+		}
+	}
+	return false
+}
+
+// contains 判断切片中是否包含 value，忽略两端空白
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if strings.TrimSpace(v) == value {
+			return true
+		}
+	}
+	return false
+}
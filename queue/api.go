@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testapi/auth"
+	"testapi/k8s"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListHandler 列出正在执行和排队中的发版，只返回调用方对服务和发版环境有 viewer 权限的
+func ListHandler(c *gin.Context) {
+	p := auth.Current(c)
+	entries := []Entry{}
+	for _, entry := range List() {
+		if p.Can(auth.RoleViewer, entryService(entry), entry.Environment) {
+			entries = append(entries, entry)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"policy": currentPolicy(), "entries": entries})
+}
+
+// entryService 队列中的服务为 服务名@集群/命名空间，检查权限时去掉发版目标，灰度服务按正式服务检查
+func entryService(entry Entry) string {
+	service, _, _ := strings.Cut(entry.Service, "@")
+	return strings.TrimSuffix(service, k8s.GrayLevelSuffix)
+}
+
+// CancelHandler 取消排队中的发版，调用方需要服务和发版环境的 releaser 权限
+func CancelHandler(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "id 不是整数"})
+		return
+	}
+	entry, ok := Get(id)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "排队不存在或已经开始执行"})
+		return
+	}
+	if !auth.Allowed(c, auth.RoleReleaser, entryService(entry), entry.Environment) {
+		return
+	}
+	if !Cancel(id) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "排队不存在或已经开始执行"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{})
+}
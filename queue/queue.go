@@ -0,0 +1,233 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testapi/k8s"
+	"time"
+)
+
+// 同一个服务有更新的发版排队时，之前还在排队的发版如何处理
+const (
+	// PolicyWait 依次执行
+	PolicyWait = "wait"
+	// PolicySupersede 之前排队中的发版不再执行，只发最新的版本
+	PolicySupersede = "supersede"
+)
+
+// 排队的操作
+const (
+	ActionDeploy   = "deploy"
+	ActionRetry    = "retry"
+	ActionRollback = "rollback"
+	ActionPromote  = "promote"
+)
+
+var (
+	policyMu sync.Mutex
+	policy   = PolicyWait
+)
+
+// SetPolicy 设置排队策略
+func SetPolicy(p string) error {
+	if p != PolicyWait && p != PolicySupersede {
+		return fmt.Errorf("不支持的排队策略: %s", p)
+	}
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	policy = p
+	return nil
+}
+
+func currentPolicy() string {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	return policy
+}
+
+// ErrCanceled 排队中的发版被接口取消
+var ErrCanceled = errors.New("排队已取消")
+
+// SupersededError 排队中的发版被同一个服务更新的发版替代
+type SupersededError struct {
+	InstanceCode string
+	Version      string
+}
+
+func (e *SupersededError) Error() string {
+	return fmt.Sprintf("已被审批单 %s 的版本 %s 替代", e.InstanceCode, e.Version)
+}
+
+// Entry 队列中的一次操作；同一个服务(服务名@集群/命名空间)同时只有一个操作在执行，其余按先后顺序排队
+type Entry struct {
+	ID           int64  `json:"id"`
+	Service      string `json:"service"`
+	Version      string `json:"version,omitempty"`
+	InstanceCode string `json:"instanceCode,omitempty"`
+	// Environment 审批单的发版环境，取消排队时按服务和环境检查权限
+	Environment string     `json:"environment,omitempty"`
+	Action      string     `json:"action"`
+	EnqueuedAt  time.Time  `json:"enqueuedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+}
+
+// Ticket 排队凭证，Wait 返回 nil 后持有服务的锁，执行完必须调用 Done
+type Ticket struct {
+	entry Entry
+	ready chan struct{}
+	err   error
+}
+
+var (
+	mu     sync.Mutex
+	nextID int64
+	// queues 服务 -> 排队中的操作，第一个为正在执行的
+	queues = map[string][]*Ticket{}
+)
+
+// Key 服务在队列中的名称，集群和命名空间按实际发版目标补全
+func Key(jobName string, target k8s.Target) string {
+	target = target.ForJob(jobName)
+	return jobName + "@" + target.Cluster + "/" + target.Namespace
+}
+
+// Enqueue 把操作放进服务的队列；策略为 supersede 且这是一次发版时，
+// 同一个服务还在排队的其他审批单的发版会被替代，它们的 Wait 返回 SupersededError
+func Enqueue(entry Entry) *Ticket {
+	mu.Lock()
+	defer mu.Unlock()
+
+	nextID++
+	entry.ID = nextID
+	entry.EnqueuedAt = time.Now()
+	entry.StartedAt = nil
+	t := &Ticket{entry: entry, ready: make(chan struct{})}
+
+	q := queues[entry.Service]
+	if entry.Action == ActionDeploy && currentPolicy() == PolicySupersede {
+		kept := q[:0]
+		for i, old := range q {
+			if i > 0 && old.entry.Action == ActionDeploy && old.entry.InstanceCode != entry.InstanceCode {
+				old.err = &SupersededError{InstanceCode: entry.InstanceCode, Version: entry.Version}
+				close(old.ready)
+				continue
+			}
+			kept = append(kept, old)
+		}
+		q = kept
+	}
+	q = append(q, t)
+	queues[entry.Service] = q
+	if len(q) == 1 {
+		t.start()
+	}
+	return t
+}
+
+// start 开始执行，调用方需要持有 mu
+func (t *Ticket) start() {
+	now := time.Now()
+	t.entry.StartedAt = &now
+	close(t.ready)
+}
+
+// Ahead 前面还有几个操作(包括正在执行的)
+func (t *Ticket) Ahead() int {
+	mu.Lock()
+	defer mu.Unlock()
+	for i, other := range queues[t.entry.Service] {
+		if other == t {
+			return i
+		}
+	}
+	return 0
+}
+
+// Wait 等到轮到这个操作；被替代或取消时返回错误，此时不需要调用 Done
+func (t *Ticket) Wait() error {
+	<-t.ready
+	return t.err
+}
+
+// Done 执行结束，释放服务的锁并开始下一个排队的操作
+func (t *Ticket) Done() {
+	mu.Lock()
+	defer mu.Unlock()
+	remove(t)
+}
+
+// remove 从队列中去掉，去掉的是正在执行的操作时开始下一个，调用方需要持有 mu
+func remove(t *Ticket) {
+	q := queues[t.entry.Service]
+	for i, other := range q {
+		if other != t {
+			continue
+		}
+		q = append(q[:i], q[i+1:]...)
+		if i == 0 && len(q) > 0 {
+			q[0].start()
+		}
+		break
+	}
+	if len(q) == 0 {
+		delete(queues, t.entry.Service)
+	} else {
+		queues[t.entry.Service] = q
+	}
+}
+
+// List 列出所有服务的队列，按服务名排序，每个服务内按排队顺序
+func List() []Entry {
+	mu.Lock()
+	defer mu.Unlock()
+	services := make([]string, 0, len(queues))
+	for service := range queues {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	var entries []Entry
+	for _, service := range services {
+		for _, t := range queues[service] {
+			entries = append(entries, t.entry)
+		}
+	}
+	return entries
+}
+
+// Get 按 ID 查找队列中的操作
+func Get(id int64) (Entry, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, q := range queues {
+		for _, t := range q {
+			if t.entry.ID == id {
+				return t.entry, true
+			}
+		}
+	}
+	return Entry{}, false
+}
+
+// Cancel 取消还在排队的操作，正在执行的不能取消；不存在或已经开始时返回 false
+func Cancel(id int64) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, q := range queues {
+		for i, t := range q {
+			if t.entry.ID != id {
+				continue
+			}
+			if i == 0 {
+				return false
+			}
+			t.err = ErrCanceled
+			close(t.ready)
+			remove(t)
+			return true
+		}
+	}
+	return false
+}
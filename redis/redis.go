@@ -2,46 +2,288 @@ package myredis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v8"
 )
 
-// CreateRedisInstance 根据操作类型对Redis执行操作。
-func CreateRedisInstance(opType, key string, values ...string) (interface{}, error) {
-	// 创建Redis客户端
-	rdb := redis.NewClient(&redis.Options{
-		Addr:     "127.0.0.1:6379",
-		Password: "123456",
-	})
-
-	// 测试连接
-	ctx := context.Background()
-	pong, err := rdb.Ping(ctx).Result()
-	if err != nil {
-		return nil, fmt.Errorf("无法连接到Redis: %w", err)
+// 部署模式
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// Config Redis 连接配置
+type Config struct {
+	// Mode standalone、sentinel 或 cluster，为空时为 standalone
+	Mode string
+	// Addrs 单机模式为一个地址，哨兵模式为哨兵地址，集群模式为任意几个节点地址
+	Addrs []string
+	// MasterName 哨兵模式的主节点名称
+	MasterName string
+	// Username/Password ACL 用户名和密码，用户名为空时使用 default 用户
+	Username string
+	Password string
+	// SentinelPassword 哨兵自身的密码，为空时不需要
+	SentinelPassword string
+	DB               int
+	PoolSize         int
+
+	// TLS 是否使用 TLS 连接，CAFile 为空时使用系统根证书
+	TLS                bool
+	CAFile             string
+	InsecureSkipVerify bool
+}
+
+// DefaultConfig 没有任何环境变量时的连接配置
+var DefaultConfig = Config{
+	Mode:     ModeStandalone,
+	Addrs:    []string{"127.0.0.1:6379"},
+	Password: "123456",
+}
+
+// ConfigFromEnv 从环境变量读取连接配置，没有设置的项使用 DefaultConfig：
+// REDIS_MODE、REDIS_ADDRS(逗号分隔)、REDIS_MASTER_NAME、REDIS_USERNAME、REDIS_PASSWORD、
+// REDIS_SENTINEL_PASSWORD、REDIS_DB、REDIS_POOL_SIZE、REDIS_TLS、REDIS_CA_FILE、REDIS_TLS_INSECURE
+func ConfigFromEnv() (Config, error) {
+	config := DefaultConfig
+	if v := os.Getenv("REDIS_MODE"); v != "" {
+		config.Mode = v
+	}
+	if v := os.Getenv("REDIS_ADDRS"); v != "" {
+		config.Addrs = strings.Split(v, ",")
+	}
+	if v, ok := os.LookupEnv("REDIS_PASSWORD"); ok {
+		config.Password = v
+	}
+	config.MasterName = os.Getenv("REDIS_MASTER_NAME")
+	config.Username = os.Getenv("REDIS_USERNAME")
+	config.SentinelPassword = os.Getenv("REDIS_SENTINEL_PASSWORD")
+	config.CAFile = os.Getenv("REDIS_CA_FILE")
+
+	for name, target := range map[string]*int{"REDIS_DB": &config.DB, "REDIS_POOL_SIZE": &config.PoolSize} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return Config{}, fmt.Errorf("%s 不是整数: %s", name, v)
+			}
+			*target = n
+		}
+	}
+	for name, target := range map[string]*bool{"REDIS_TLS": &config.TLS, "REDIS_TLS_INSECURE": &config.InsecureSkipVerify} {
+		if v := os.Getenv(name); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return Config{}, fmt.Errorf("%s 不是布尔值: %s", name, v)
+			}
+			*target = b
+		}
+	}
+	return config, nil
+}
+
+// Client 长期复用的 Redis 客户端，内部带连接池，可以并发使用
+type Client struct {
+	rdb redis.UniversalClient
+}
+
+// NewClient 按配置创建客户端，不会立即建立连接
+func NewClient(config Config) (*Client, error) {
+	if len(config.Addrs) == 0 {
+		return nil, fmt.Errorf("没有配置 Redis 地址")
 	}
-	fmt.Println("成功连接到Redis: ", pong)
 
-	// 根据操作类型执行相应操作
-	switch opType {
-	case "get":
-		// 检查键是否存在
-		exists, err := rdb.Exists(ctx, key).Result()
-		if err != nil {
-			return nil, fmt.Errorf("检查键存在失败: %w", err)
+	var tlsConfig *tls.Config
+	if config.TLS {
+		tlsConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: config.InsecureSkipVerify,
 		}
-		return exists > 0, nil
-	case "set":
-		if len(values) == 0 {
-			return nil, fmt.Errorf("缺少值参数")
+		if config.CAFile != "" {
+			pem, err := os.ReadFile(config.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("读取 Redis CA 证书失败: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("redis CA 证书 %s 无效", config.CAFile)
+			}
+			tlsConfig.RootCAs = pool
 		}
-		err := rdb.Set(ctx, key, values[0], 0).Err()
-		if err != nil {
-			return nil, fmt.Errorf("设置值失败: %w", err)
+	}
+
+	var rdb redis.UniversalClient
+	switch config.Mode {
+	case "", ModeStandalone:
+		rdb = redis.NewClient(&redis.Options{
+			Addr:      config.Addrs[0],
+			Username:  config.Username,
+			Password:  config.Password,
+			DB:        config.DB,
+			PoolSize:  config.PoolSize,
+			TLSConfig: tlsConfig,
+		})
+	case ModeSentinel:
+		if config.MasterName == "" {
+			return nil, fmt.Errorf("哨兵模式需要配置主节点名称")
 		}
-		return "", nil
+		rdb = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       config.MasterName,
+			SentinelAddrs:    config.Addrs,
+			SentinelPassword: config.SentinelPassword,
+			Username:         config.Username,
+			Password:         config.Password,
+			DB:               config.DB,
+			PoolSize:         config.PoolSize,
+			TLSConfig:        tlsConfig,
+		})
+	case ModeCluster:
+		rdb = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     config.Addrs,
+			Username:  config.Username,
+			Password:  config.Password,
+			PoolSize:  config.PoolSize,
+			TLSConfig: tlsConfig,
+		})
 	default:
-		return nil, fmt.Errorf("不支持的操作类型: %s", opType)
+		return nil, fmt.Errorf("不支持的 Redis 模式: %s", config.Mode)
+	}
+	return &Client{rdb: rdb}, nil
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultClient *Client
+)
+
+// Init 用配置创建默认客户端，替换之前的默认客户端；不检查连接，Redis 暂时不可用时之后的操作会返回错误
+func Init(config Config) error {
+	client, err := NewClient(config)
+	if err != nil {
+		return err
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultClient != nil {
+		defaultClient.Close()
+	}
+	defaultClient = client
+	return nil
+}
+
+// Default 返回默认客户端，没有调用过 Init 时按 DefaultConfig 创建
+func Default() *Client {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultClient == nil {
+		// DefaultConfig 一定有效
+		defaultClient, _ = NewClient(DefaultConfig)
+	}
+	return defaultClient
+}
+
+// Close 关闭默认客户端
+func Close() error {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultClient == nil {
+		return nil
+	}
+	err := defaultClient.Close()
+	defaultClient = nil
+	return err
+}
+
+// Ping 检查连接是否正常，用于健康检查
+func (c *Client) Ping(ctx context.Context) error {
+	if err := c.rdb.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("无法连接到Redis: %w", err)
+	}
+	return nil
+}
+
+// Close 关闭连接池
+func (c *Client) Close() error {
+	return c.rdb.Close()
+}
+
+// Exists 检查键是否存在
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := c.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("检查键存在失败: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Set 设置键的值，expiration 为 0 时不过期
+func (c *Client) Set(ctx context.Context, key, value string, expiration time.Duration) error {
+	if err := c.rdb.Set(ctx, key, value, expiration).Err(); err != nil {
+		return fmt.Errorf("设置值失败: %w", err)
+	}
+	return nil
+}
+
+// HSet 设置哈希字段
+func (c *Client) HSet(ctx context.Context, key, field, value string) error {
+	if err := c.rdb.HSet(ctx, key, field, value).Err(); err != nil {
+		return fmt.Errorf("设置哈希字段失败: %w", err)
+	}
+	return nil
+}
+
+// HGet 读取哈希字段，字段不存在时返回 false
+func (c *Client) HGet(ctx context.Context, key, field string) (string, bool, error) {
+	value, err := c.rdb.HGet(ctx, key, field).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("读取哈希字段失败: %w", err)
+	}
+	return value, true, nil
+}
+
+// HDel 删除哈希字段
+func (c *Client) HDel(ctx context.Context, key string, fields ...string) error {
+	if err := c.rdb.HDel(ctx, key, fields...).Err(); err != nil {
+		return fmt.Errorf("删除哈希字段失败: %w", err)
+	}
+	return nil
+}
+
+// HGetAll 读取整个哈希
+func (c *Client) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	result, err := c.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取哈希失败: %w", err)
+	}
+	return result, nil
+}
+
+// SetNX 键不存在时设置值，返回是否设置成功，expiration 为 0 时不过期
+func (c *Client) SetNX(ctx context.Context, key, value string, expiration time.Duration) (bool, error) {
+	ok, err := c.rdb.SetNX(ctx, key, value, expiration).Result()
+	if err != nil {
+		return false, fmt.Errorf("设置值失败: %w", err)
+	}
+	return ok, nil
+}
+
+// HSetNX 哈希字段不存在时设置值，返回是否设置成功
+func (c *Client) HSetNX(ctx context.Context, key, field, value string) (bool, error) {
+	ok, err := c.rdb.HSetNX(ctx, key, field, value).Result()
+	if err != nil {
+		return false, fmt.Errorf("设置哈希字段失败: %w", err)
 	}
+	return ok, nil
 }
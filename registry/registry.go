@@ -0,0 +1,250 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/yaml"
+)
+
+// KindDeployment 目前只支持 Deployment 类型的工作负载
+const KindDeployment = "Deployment"
+
+// GrayLevelSuffix 灰度工作负载的名称后缀
+const GrayLevelSuffix = "-gray-level"
+
+// 发布策略
+const (
+	// StrategyRolling 直接滚动更新服务本身，默认策略
+	StrategyRolling = "rolling"
+	// StrategyGray 更新服务对应的 -gray-level 灰度工作负载，验证后通过卡片按钮转正
+	StrategyGray = "gray"
+)
+
+// DefaultTagPattern 未单独声明时版本号需要满足的格式，与镜像 tag 的规则一致
+const DefaultTagPattern = `^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`
+
+// Rollout 服务的发布策略
+type Rollout struct {
+	// Strategy 默认发布策略，rolling 或 gray，发版清单中填写的策略优先
+	Strategy string `json:"strategy,omitempty"`
+	// TimeoutSeconds 等待新版本 Pod 就绪的超时时间，为 0 时使用默认的 2 分钟
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// Service 一个可发版的服务
+type Service struct {
+	// Name 发版清单中填写的服务名
+	Name string `json:"name"`
+	// Kind/Workload 工作负载类型和名称，名称为空时与服务名相同
+	Kind     string `json:"kind,omitempty"`
+	Workload string `json:"workload,omitempty"`
+	// Cluster/Namespace 默认发版目标，发版清单中填写的目标优先
+	Cluster   string `json:"cluster,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	// Containers 需要更新镜像的容器，为空时与工作负载名称相同
+	Containers []string `json:"containers,omitempty"`
+	// ImageRepository 镜像仓库地址(不含 tag)，为空时沿用容器当前镜像的仓库
+	ImageRepository string `json:"imageRepository,omitempty"`
+	// TagPattern 版本号需要匹配的正则，为空时使用 DefaultTagPattern
+	TagPattern string `json:"tagPattern,omitempty"`
+	// Route 通知路由，对应 sendmsg.APIMap 的 key，为空时按服务名匹配
+	Route string `json:"route,omitempty"`
+	// Owners 服务负责人
+	Owners  []string `json:"owners,omitempty"`
+	Rollout Rollout  `json:"rollout,omitempty"`
+	// Stage 所属的发版阶段，为空时放在最后一个阶段之后
+	Stage string `json:"stage,omitempty"`
+}
+
+// Stage 发版阶段，按配置顺序依次执行，前一个阶段有服务失败时后面的阶段不再执行
+type Stage struct {
+	Name string `json:"name"`
+	// Concurrency 阶段内同时发版的服务数，为 0 时逐个发版
+	Concurrency int `json:"concurrency,omitempty"`
+}
+
+// Image 返回版本对应的完整镜像，没有配置镜像仓库时返回空字符串
+func (s Service) Image(version string) string {
+	if s.ImageRepository == "" {
+		return ""
+	}
+	return s.ImageRepository + ":" + version
+}
+
+// HasContainer 容器是否需要更新镜像
+func (s Service) HasContainer(name string) bool {
+	for _, container := range s.Containers {
+		if container == name {
+			return true
+		}
+	}
+	return false
+}
+
+// withDefaults 补全未填写的字段
+func (s Service) withDefaults() Service {
+	if s.Kind == "" {
+		s.Kind = KindDeployment
+	}
+	if s.Workload == "" {
+		s.Workload = s.Name
+	}
+	if len(s.Containers) == 0 {
+		s.Containers = []string{s.Workload}
+	}
+	if s.TagPattern == "" {
+		s.TagPattern = DefaultTagPattern
+	}
+	return s
+}
+
+// validate 检查服务配置是否有效
+func (s Service) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("服务名不能为空")
+	}
+	if s.Kind != KindDeployment {
+		return fmt.Errorf("服务 %s 的工作负载类型 %s 暂不支持", s.Name, s.Kind)
+	}
+	if s.Rollout.Strategy != "" && s.Rollout.Strategy != StrategyRolling && s.Rollout.Strategy != StrategyGray {
+		return fmt.Errorf("服务 %s 的发布策略 %s 无效", s.Name, s.Rollout.Strategy)
+	}
+	if _, err := regexp.Compile(s.TagPattern); err != nil {
+		return fmt.Errorf("服务 %s 的版本号规则 %s 无效: %v", s.Name, s.TagPattern, err)
+	}
+	return nil
+}
+
+// Config 服务登记的配置文件格式，YAML 或 JSON
+type Config struct {
+	Stages   []Stage   `json:"stages,omitempty"`
+	Services []Service `json:"services"`
+}
+
+// Registry 解析后的服务登记
+type Registry struct {
+	Stages   []Stage
+	Services map[string]Service
+}
+
+var (
+	mu       sync.RWMutex
+	services = map[string]Service{}
+	stages   []Stage
+)
+
+// Parse 解析服务登记配置，YAML 和 JSON 都可以
+func Parse(data []byte) (Registry, error) {
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return Registry{}, fmt.Errorf("解析服务登记配置失败: %w", err)
+	}
+
+	stageNames := make(map[string]bool, len(config.Stages))
+	for _, stage := range config.Stages {
+		if stage.Name == "" {
+			return Registry{}, fmt.Errorf("发版阶段名称不能为空")
+		}
+		if stageNames[stage.Name] {
+			return Registry{}, fmt.Errorf("发版阶段 %s 重复", stage.Name)
+		}
+		if stage.Concurrency < 0 {
+			return Registry{}, fmt.Errorf("发版阶段 %s 的并发数不能为负数", stage.Name)
+		}
+		stageNames[stage.Name] = true
+	}
+
+	result := make(map[string]Service, len(config.Services))
+	for _, service := range config.Services {
+		service = service.withDefaults()
+		if err := service.validate(); err != nil {
+			return Registry{}, err
+		}
+		if service.Stage != "" && !stageNames[service.Stage] {
+			return Registry{}, fmt.Errorf("服务 %s 的发版阶段 %s 没有定义", service.Name, service.Stage)
+		}
+		if _, ok := result[service.Name]; ok {
+			return Registry{}, fmt.Errorf("服务 %s 重复登记", service.Name)
+		}
+		result[service.Name] = service
+	}
+	return Registry{Stages: config.Stages, Services: result}, nil
+}
+
+// Set 替换当前登记的服务和发版阶段
+func Set(registered Registry) {
+	mu.Lock()
+	defer mu.Unlock()
+	services = registered.Services
+	stages = registered.Stages
+}
+
+// Stages 返回配置的发版阶段，按执行顺序排列
+func Stages() []Stage {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]Stage(nil), stages...)
+}
+
+// Load 从文件加载服务登记配置
+func Load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取服务登记配置失败: %w", err)
+	}
+	registered, err := Parse(data)
+	if err != nil {
+		return err
+	}
+	Set(registered)
+	return nil
+}
+
+// Configured 是否登记了服务；没有登记时所有服务按旧约定处理: 工作负载名 = 容器名 = 服务名
+func Configured() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(services) > 0
+}
+
+// Lookup 查找登记的服务，name 可以带 -gray-level 后缀
+func Lookup(name string) (Service, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if service, ok := services[name]; ok {
+		return service, true
+	}
+	if base := strings.TrimSuffix(name, GrayLevelSuffix); base != name {
+		if service, ok := services[base]; ok {
+			return service.GrayLevel(), true
+		}
+	}
+	return Service{}, false
+}
+
+// Resolve 返回服务的描述，没有登记时按旧约定生成
+func Resolve(name string) Service {
+	if service, ok := Lookup(name); ok {
+		return service
+	}
+	return Service{Name: name}.withDefaults()
+}
+
+// GrayLevel 返回服务对应的灰度服务，工作负载名称加上 -gray-level 后缀，容器和镜像不变
+func (s Service) GrayLevel() Service {
+	if strings.HasSuffix(s.Name, GrayLevelSuffix) {
+		return s
+	}
+	gray := s
+	gray.Name = s.Name + GrayLevelSuffix
+	gray.Workload = s.Workload + GrayLevelSuffix
+	// 旧约定中灰度 Deployment 的容器与 Deployment 同名
+	if len(s.Containers) == 1 && s.Containers[0] == s.Workload {
+		gray.Containers = []string{gray.Workload}
+	}
+	return gray
+}
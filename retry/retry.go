@@ -0,0 +1,147 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Policy 重试策略，第 n 次重试前等待 InitialDelay * Multiplier^(n-1)，不超过 MaxDelay，
+// 再在 [1-Jitter, 1+Jitter] 范围内随机浮动，避免多个调用同时重试
+type Policy struct {
+	// MaxAttempts 最多执行几次(包括第一次)，小于等于 1 时不重试
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	Jitter       float64
+}
+
+// DefaultPolicy 没有任何环境变量时的重试策略
+var DefaultPolicy = Policy{
+	MaxAttempts:  4,
+	InitialDelay: time.Second,
+	MaxDelay:     30 * time.Second,
+	Multiplier:   2,
+	Jitter:       0.2,
+}
+
+// PolicyFromEnv 从环境变量 RETRY_MAX_ATTEMPTS、RETRY_INITIAL_DELAY、RETRY_MAX_DELAY、RETRY_MULTIPLIER、RETRY_JITTER
+// 读取重试策略，时间格式如 500ms、2s，没有设置的项使用 DefaultPolicy
+func PolicyFromEnv() (Policy, error) {
+	policy := DefaultPolicy
+	if v := os.Getenv("RETRY_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return Policy{}, fmt.Errorf("RETRY_MAX_ATTEMPTS 不是整数: %s", v)
+		}
+		policy.MaxAttempts = n
+	}
+	for name, target := range map[string]*time.Duration{"RETRY_INITIAL_DELAY": &policy.InitialDelay, "RETRY_MAX_DELAY": &policy.MaxDelay} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return Policy{}, fmt.Errorf("%s 不是有效的时间: %s", name, v)
+			}
+			*target = d
+		}
+	}
+	for name, target := range map[string]*float64{"RETRY_MULTIPLIER": &policy.Multiplier, "RETRY_JITTER": &policy.Jitter} {
+		if v := os.Getenv(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return Policy{}, fmt.Errorf("%s 不是数字: %s", name, v)
+			}
+			*target = f
+		}
+	}
+	return policy, nil
+}
+
+var (
+	policyMu sync.Mutex
+	policy   = DefaultPolicy
+)
+
+// SetPolicy 设置全局重试策略
+func SetPolicy(p Policy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	policy = p
+}
+
+func currentPolicy() Policy {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	return policy
+}
+
+// retryableError 标记可以重试的错误
+type retryableError struct {
+	err error
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// Retryable 把错误标记为可以重试，例如接口 5xx、限流、连接失败；err 为 nil 时返回 nil
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &retryableError{err: err}
+}
+
+// IsRetryable 判断错误是否可以重试：标记过的错误和网络超时可以重试，其余都视为永久错误
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var r *retryableError
+	if errors.As(err, &r) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// Do 按全局重试策略执行 fn，可以重试的错误等待后重试，永久错误和重试次数用完时返回最后一次的错误；
+// name 用于日志
+func Do(ctx context.Context, name string, fn func() error) error {
+	p := currentPolicy()
+	delay := p.InitialDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !IsRetryable(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+
+		wait := jitter(delay, p.Jitter)
+		log.Printf("%s 失败，%v 后第 %d 次重试: %v", name, wait.Round(time.Millisecond), attempt, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		delay = time.Duration(float64(delay) * p.Multiplier)
+		if p.MaxDelay > 0 && delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
+
+// jitter 在 [1-factor, 1+factor] 范围内随机浮动
+func jitter(d time.Duration, factor float64) time.Duration {
+	if factor <= 0 {
+		return d
+	}
+	return time.Duration(float64(d) * (1 - factor + 2*factor*rand.Float64()))
+}
@@ -0,0 +1,73 @@
+package sendmsg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DingTalkNotifier 通过钉钉自定义机器人发送 ActionCard
+type DingTalkNotifier struct {
+	Webhook string
+	// Secret 机器人"加签"密钥，为空时不签名
+	Secret string
+}
+
+// dingTalkResponse 钉钉机器人响应，出错时同样返回 HTTP 200
+type dingTalkResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// dingTalkSign 计算钉钉加签: urlencode(base64(HmacSHA256(secret, timestamp + "\n" + secret)))
+func dingTalkSign(secret string, timestamp int64) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(fmt.Sprintf("%d\n%s", timestamp, secret)))
+	return url.QueryEscape(base64.StdEncoding.EncodeToString(h.Sum(nil)))
+}
+
+// Notify 发送 ActionCard，附带的链接渲染为按钮
+func (n *DingTalkNotifier) Notify(ctx context.Context, event Event) error {
+	title := statusTitle(event.Status)
+	actionCard := map[string]interface{}{
+		"title":          title,
+		"text":           fmt.Sprintf("### %s\n\n%s", title, strings.ReplaceAll(eventLines(event), "\n", "\n\n")),
+		"btnOrientation": "0",
+	}
+	if len(event.Links) > 0 {
+		var btns []map[string]string
+		for _, link := range event.Links {
+			btns = append(btns, map[string]string{"title": link.Title, "actionURL": link.URL})
+		}
+		actionCard["btns"] = btns
+	}
+
+	webhook := n.Webhook
+	if n.Secret != "" {
+		timestamp := time.Now().UnixMilli()
+		webhook = fmt.Sprintf("%s&timestamp=%d&sign=%s", webhook, timestamp, dingTalkSign(n.Secret, timestamp))
+	}
+
+	body, err := postJSON(ctx, webhook, map[string]interface{}{
+		"msgtype":    "actionCard",
+		"actionCard": actionCard,
+	})
+	if err != nil {
+		return err
+	}
+
+	var response dingTalkResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("decode response failed: %w, body: %s", err, string(body))
+	}
+	if response.ErrCode != 0 {
+		return fmt.Errorf("dingtalk webhook error, code: %d, msg: %s", response.ErrCode, response.ErrMsg)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package sendmsg
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDingTalkNotifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		response string
+		wantErr  bool
+	}{
+		{name: "成功", response: `{"errcode":0,"errmsg":"ok"}`},
+		{name: "加签", secret: "SECxxx", response: `{"errcode":0,"errmsg":"ok"}`},
+		{name: "业务错误", response: `{"errcode":310000,"errmsg":"sign not match"}`, wantErr: true},
+		{name: "响应不是 JSON", response: `<html>`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, requests := newWebhook(t, 200, tt.response)
+			n := &DingTalkNotifier{Webhook: server.URL + "/robot/send?access_token=abc", Secret: tt.secret}
+
+			err := n.Notify(context.Background(), testEvent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(*requests) != 1 {
+				t.Fatalf("收到 %d 个请求, want 1", len(*requests))
+			}
+			request := (*requests)[0]
+			if got := field(t, request.Body, "msgtype"); got != "actionCard" {
+				t.Errorf("msgtype = %v", got)
+			}
+			if text := field(t, request.Body, "actionCard", "text").(string); !strings.Contains(text, "order-api") {
+				t.Errorf("text 缺少服务名: %s", text)
+			}
+			btns := field(t, request.Body, "actionCard", "btns").([]interface{})
+			if len(btns) != len(testEvent.Links) {
+				t.Fatalf("按钮数 = %d, want %d", len(btns), len(testEvent.Links))
+			}
+			if got := field(t, btns[0], "actionURL"); got != testEvent.Links[0].URL {
+				t.Errorf("actionURL = %v", got)
+			}
+
+			query, err := url.ParseQuery(request.Query)
+			if err != nil {
+				t.Fatalf("parse query: %v", err)
+			}
+			if query.Get("access_token") != "abc" {
+				t.Errorf("access_token 丢失: %s", request.Query)
+			}
+			if tt.secret == "" {
+				if query.Has("sign") {
+					t.Errorf("没有密钥时不应签名: %s", request.Query)
+				}
+				return
+			}
+			timestamp, err := strconv.ParseInt(query.Get("timestamp"), 10, 64)
+			if err != nil {
+				t.Fatalf("timestamp: %v", err)
+			}
+			want, _ := url.QueryUnescape(dingTalkSign(tt.secret, timestamp))
+			if query.Get("sign") != want {
+				t.Errorf("sign = %s, want %s", query.Get("sign"), want)
+			}
+		})
+	}
+}
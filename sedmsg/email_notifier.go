@@ -0,0 +1,76 @@
+package sendmsg
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPConfig 发送邮件通知使用的 SMTP 服务器
+type SMTPConfig struct {
+	// Addr 形如 smtp.example.com:587
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// SMTP 全局 SMTP 配置，使用 email 类型的路由前需要填写
+var SMTP = SMTPConfig{
+	Addr:     "",
+	Username: "",
+	Password: "",
+	From:     "",
+}
+
+// EmailNotifier 通过 SMTP 发送邮件通知
+type EmailNotifier struct {
+	SMTP SMTPConfig
+	To   []string
+}
+
+// Notify 发送纯文本邮件，附带的链接追加在正文末尾
+func (n *EmailNotifier) Notify(ctx context.Context, event Event) error {
+	if n.SMTP.Addr == "" || len(n.To) == 0 {
+		return fmt.Errorf("smtp address or recipients not configured")
+	}
+
+	subject := fmt.Sprintf("[%s] %s %s", statusTitle(event.Status), event.Service, event.Version)
+	text := strings.ReplaceAll(eventLines(event), "**", "")
+	for _, link := range event.Links {
+		text += fmt.Sprintf("%s: %s\n", link.Title, link.URL)
+	}
+
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("From: %s\r\n", n.SMTP.From))
+	msg.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(n.To, ", ")))
+	msg.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject)))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if n.SMTP.Username != "" {
+		host, _, err := net.SplitHostPort(n.SMTP.Addr)
+		if err != nil {
+			return fmt.Errorf("invalid smtp address %s: %w", n.SMTP.Addr, err)
+		}
+		auth = smtp.PlainAuth("", n.SMTP.Username, n.SMTP.Password, host)
+	}
+
+	// net/smtp 不支持 context，放到 goroutine 中以便超时返回
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(n.SMTP.Addr, auth, n.SMTP.From, n.To, []byte(msg.String()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,136 @@
+package sendmsg
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+// smtpMessage 测试 SMTP 服务器收到的一封邮件
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// newSMTPServer 启动一个只支持明文、不需要认证的最小 SMTP 服务器，收到的邮件写入返回的 channel；
+// rejectRcpt 为 true 时拒绝所有收件人
+func newSMTPServer(t *testing.T, rejectRcpt bool) (string, <-chan smtpMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan smtpMessage, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		var msg smtpMessage
+		reply("220 localhost ESMTP test")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			cmd := strings.ToUpper(line)
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(cmd, "MAIL FROM:"):
+				msg.From = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(cmd, "RCPT TO:"):
+				if rejectRcpt {
+					reply("550 no such user")
+					continue
+				}
+				msg.To = append(msg.To, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 end with .")
+				var data strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				msg.Data = data.String()
+				messages <- msg
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 OK")
+			}
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestEmailNotifier(t *testing.T) {
+	tests := []struct {
+		name       string
+		addr       bool
+		to         []string
+		rejectRcpt bool
+		wantErr    bool
+	}{
+		{name: "成功", addr: true, to: []string{"dev@example.com", "ops@example.com"}},
+		{name: "收件人被拒绝", addr: true, to: []string{"dev@example.com"}, rejectRcpt: true, wantErr: true},
+		{name: "没有配置 SMTP", to: []string{"dev@example.com"}, wantErr: true},
+		{name: "没有收件人", addr: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, messages := newSMTPServer(t, tt.rejectRcpt)
+			config := SMTPConfig{From: "bot@example.com"}
+			if tt.addr {
+				config.Addr = addr
+			}
+			n := &EmailNotifier{SMTP: config, To: tt.to}
+
+			err := n.Notify(context.Background(), testEvent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			msg := <-messages
+			if msg.From != "bot@example.com" {
+				t.Errorf("MAIL FROM = %s", msg.From)
+			}
+			if strings.Join(msg.To, ",") != strings.Join(tt.to, ",") {
+				t.Errorf("RCPT TO = %v, want %v", msg.To, tt.to)
+			}
+			for _, want := range []string{
+				"To: dev@example.com, ops@example.com",
+				"Subject: =?UTF-8?b?",
+				"服务: order-api",
+				"查看审批单: https://approval.example.com/i/1",
+			} {
+				if !strings.Contains(msg.Data, want) {
+					t.Errorf("邮件缺少 %q:\n%s", want, msg.Data)
+				}
+			}
+		})
+	}
+}
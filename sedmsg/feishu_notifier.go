@@ -0,0 +1,37 @@
+package sendmsg
+
+import (
+	"context"
+)
+
+// FeishuNotifier 通过飞书自定义机器人发送卡片
+type FeishuNotifier struct {
+	Route Route
+}
+
+// Notify 发送飞书卡片，附带的链接渲染为按钮
+func (n *FeishuNotifier) Notify(ctx context.Context, event Event) error {
+	card := BuildCard(eventLines(event), statusColor(event.Status))
+	card["header"].(map[string]interface{})["title"].(map[string]interface{})["content"] = statusTitle(event.Status)
+
+	if len(event.Links) > 0 {
+		var actions []interface{}
+		for _, link := range event.Links {
+			actions = append(actions, map[string]interface{}{
+				"tag":  "button",
+				"text": map[string]interface{}{"tag": "plain_text", "content": link.Title},
+				"type": "default",
+				"url":  link.URL,
+			})
+		}
+		card["elements"] = append(card["elements"].([]interface{}), map[string]interface{}{
+			"tag":     "action",
+			"actions": actions,
+		})
+	}
+
+	return sendFeishuMsg(ctx, n.Route, map[string]interface{}{
+		"msg_type": "interactive",
+		"card":     card,
+	})
+}
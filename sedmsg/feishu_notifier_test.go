@@ -0,0 +1,65 @@
+package sendmsg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestFeishuNotifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		status   int
+		response string
+		wantErr  bool
+	}{
+		{name: "成功", response: `{"code":0,"msg":"success"}`},
+		{name: "签名", secret: "s3cret", response: `{"code":0,"msg":"success"}`},
+		{name: "业务错误", response: `{"code":19021,"msg":"sign match fail"}`, wantErr: true},
+		{name: "旧版错误", response: `{"StatusCode":9499,"StatusMessage":"Bad Request"}`, wantErr: true},
+		{name: "HTTP 错误", status: http.StatusInternalServerError, response: `oops`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			server, requests := newWebhook(t, status, tt.response)
+			n := &FeishuNotifier{Route: Route{Webhook: server.URL, Secret: tt.secret}}
+
+			err := n.Notify(context.Background(), testEvent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(*requests) != 1 {
+				t.Fatalf("收到 %d 个请求, want 1", len(*requests))
+			}
+			body := (*requests)[0].Body
+			if got := field(t, body, "msg_type"); got != "interactive" {
+				t.Errorf("msg_type = %v", got)
+			}
+			if got := field(t, body, "card", "header", "title", "content"); got != "发版成功" {
+				t.Errorf("title = %v", got)
+			}
+			if got := field(t, body, "card", "header", "template"); got != "green" {
+				t.Errorf("template = %v", got)
+			}
+			actions := field(t, body, "card", "elements", 1, "actions").([]interface{})
+			if len(actions) != len(testEvent.Links) {
+				t.Fatalf("按钮数 = %d, want %d", len(actions), len(testEvent.Links))
+			}
+			for i, link := range testEvent.Links {
+				if got := field(t, actions[i], "url"); got != link.URL {
+					t.Errorf("按钮 %d url = %v, want %s", i, got, link.URL)
+				}
+			}
+
+			_, signed := body["sign"]
+			if signed != (tt.secret != "") {
+				t.Errorf("sign 存在 = %v, 配置了密钥 = %v", signed, tt.secret != "")
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package sendmsg
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ApprovalURLs 审批来源 -> 审批单详情页地址，地址中的 {instance} 替换为审批单号；没有配置的来源不附带审批单链接
+var ApprovalURLs = map[string]string{}
+
+// chatLinkURL 打开飞书群聊的 AppLink，发版卡片发在服务路由的群中
+const chatLinkURL = "https://applink.feishu.cn/client/chat/open?openChatId=%s"
+
+// ReleaseLinks 发版通知附带的链接：审批单详情页和服务所在群中的发版卡片
+func ReleaseLinks(source, instanceCode, jobName string) []Link {
+	var links []Link
+	if tmpl := ApprovalURLs[source]; tmpl != "" && instanceCode != "" {
+		links = append(links, Link{
+			Title: "查看审批单",
+			URL:   strings.ReplaceAll(tmpl, "{instance}", url.QueryEscape(instanceCode)),
+		})
+	}
+	if route := ResolveRoute(jobName); route.ChatID != "" {
+		links = append(links, Link{
+			Title: "查看发版卡片",
+			URL:   fmt.Sprintf(chatLinkURL, url.QueryEscape(route.ChatID)),
+		})
+	}
+	return links
+}
@@ -0,0 +1,47 @@
+package sendmsg
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReleaseLinks(t *testing.T) {
+	defer func(urls map[string]string, routes map[string]Route) { ApprovalURLs, APIMap = urls, routes }(ApprovalURLs, APIMap)
+	ApprovalURLs = map[string]string{"feishu": "https://approval.example.com/detail?code={instance}"}
+
+	tests := []struct {
+		name   string
+		source string
+		code   string
+		chatID string
+		want   []Link
+	}{
+		{
+			name:   "审批单和发版卡片",
+			source: "feishu",
+			code:   "A B",
+			chatID: "oc_1",
+			want: []Link{
+				{Title: "查看审批单", URL: "https://approval.example.com/detail?code=A+B"},
+				{Title: "查看发版卡片", URL: "https://applink.feishu.cn/client/chat/open?openChatId=oc_1"},
+			},
+		},
+		{
+			name:   "来源没有配置地址",
+			source: "dingtalk",
+			code:   "1",
+			chatID: "oc_1",
+			want:   []Link{{Title: "查看发版卡片", URL: "https://applink.feishu.cn/client/chat/open?openChatId=oc_1"}},
+		},
+		{name: "路由没有群", source: "feishu", code: "1", want: []Link{{Title: "查看审批单", URL: "https://approval.example.com/detail?code=1"}}},
+		{name: "都没有", source: "wecom", code: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			APIMap = map[string]Route{mydefault: {Webhook: "https://example.com/hook", ChatID: tt.chatID}}
+			if got := ReleaseLinks(tt.source, tt.code, "order-api"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReleaseLinks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
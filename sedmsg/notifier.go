@@ -0,0 +1,150 @@
+package sendmsg
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// 通知渠道类型，对应 Route.Type，为空时使用飞书
+const (
+	TypeFeishu   = "feishu"
+	TypeDingTalk = "dingtalk"
+	TypeWeCom    = "wecom"
+	TypeSlack    = "slack"
+	TypeEmail    = "email"
+)
+
+// 发版事件状态，与 k8s 包中的发版进度状态保持一致
+const (
+	StatusPending    = "pending"
+	StatusRolling    = "rolling"
+	StatusReady      = "ready"
+	StatusFailed     = "failed"
+	StatusRolledBack = "rolledback"
+	StatusSkipped    = "skipped"
+	// StatusInfo 不属于发版进度的普通通知
+	StatusInfo = "info"
+)
+
+// Link 通知中附带的链接，例如审批单、监控面板
+type Link struct {
+	Title string
+	URL   string
+}
+
+// Event 一次发版事件，各个通知渠道根据它渲染自己的消息格式
+type Event struct {
+	Service string
+	Version string
+	Status  string
+	Message string
+	Links   []Link
+	Time    time.Time
+}
+
+// Notifier 通知渠道
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// NotifierFor 根据路由类型创建对应的通知渠道
+func NotifierFor(route Route) (Notifier, error) {
+	switch route.Type {
+	case "", TypeFeishu:
+		return &FeishuNotifier{Route: route}, nil
+	case TypeDingTalk:
+		return &DingTalkNotifier{Webhook: route.Webhook, Secret: route.Secret}, nil
+	case TypeWeCom:
+		return &WeComNotifier{Webhook: route.Webhook}, nil
+	case TypeSlack:
+		return &SlackNotifier{Webhook: route.Webhook}, nil
+	case TypeEmail:
+		return &EmailNotifier{SMTP: SMTP, To: route.To}, nil
+	default:
+		return nil, fmt.Errorf("不支持的通知类型: %s", route.Type)
+	}
+}
+
+// Notify 把事件发送到服务对应的通知渠道
+func Notify(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	route := ResolveRoute(event.Service)
+	notifier, err := NotifierFor(route)
+	if err != nil {
+		log.Printf("创建通知渠道失败: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := notifier.Notify(ctx, event); err != nil {
+		log.Printf("发送 %s 的发版通知失败: %v", event.Service, err)
+		return
+	}
+	fmt.Println("告警已成功发送")
+}
+
+// statusTitle 事件状态对应的标题
+func statusTitle(status string) string {
+	switch status {
+	case StatusPending:
+		return "发版等待中"
+	case StatusRolling:
+		return "发版进行中"
+	case StatusReady:
+		return "发版成功"
+	case StatusFailed:
+		return "发版失败"
+	case StatusRolledBack:
+		return "已回滚"
+	case StatusSkipped:
+		return "已跳过"
+	default:
+		return "发版通知"
+	}
+}
+
+// statusColor 事件状态对应的飞书卡片颜色
+func statusColor(status string) string {
+	switch status {
+	case StatusReady:
+		return "green"
+	case StatusFailed:
+		return "red"
+	case StatusRolledBack:
+		return "orange"
+	default:
+		return "blue"
+	}
+}
+
+// colorStatus 兼容旧的按颜色发送的接口，把颜色转换为事件状态
+func colorStatus(colors string) string {
+	switch colors {
+	case "green":
+		return StatusReady
+	case "red":
+		return StatusFailed
+	default:
+		return StatusInfo
+	}
+}
+
+// eventLines 渲染服务、版本、时间等通用字段，markdown 格式
+func eventLines(event Event) string {
+	text := ""
+	if event.Service != "" {
+		text += fmt.Sprintf("**服务**: %s\n", event.Service)
+	}
+	if event.Version != "" {
+		text += fmt.Sprintf("**版本**: %s\n", event.Version)
+	}
+	text += fmt.Sprintf("**时间**: %s\n", event.Time.Format("2006-01-02 15:04:05"))
+	text += fmt.Sprintf("**详情**: %s\n", event.Message)
+	return text
+}
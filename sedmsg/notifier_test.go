@@ -0,0 +1,78 @@
+package sendmsg
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testEvent 各个通知渠道共用的测试事件
+var testEvent = Event{
+	Service: "order-api",
+	Version: "v1.2.3",
+	Status:  StatusReady,
+	Message: "发版完成",
+	Links: []Link{
+		{Title: "查看审批单", URL: "https://approval.example.com/i/1"},
+		{Title: "查看发版卡片", URL: "https://applink.feishu.cn/client/chat/open?openChatId=oc_1"},
+	},
+	Time: time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local),
+}
+
+// webhookRequest 测试服务器收到的一次请求
+type webhookRequest struct {
+	Query string
+	Body  map[string]interface{}
+}
+
+// newWebhook 启动一个返回 response 的 webhook 测试服务器，收到的请求写入 requests
+func newWebhook(t *testing.T, status int, response string) (*httptest.Server, *[]webhookRequest) {
+	t.Helper()
+	var requests []webhookRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("decode body %s: %v", data, err)
+		}
+		requests = append(requests, webhookRequest{Query: r.URL.RawQuery, Body: body})
+		w.WriteHeader(status)
+		io.WriteString(w, response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+// field 按路径读取 JSON 中的字段，数组用下标
+func field(t *testing.T, v interface{}, path ...interface{}) interface{} {
+	t.Helper()
+	for _, p := range path {
+		switch key := p.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				t.Fatalf("%v 不是对象，无法读取 %q", v, key)
+			}
+			v = m[key]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || key >= len(a) {
+				t.Fatalf("%v 不是长度大于 %d 的数组", v, key)
+			}
+			v = a[key]
+		}
+	}
+	return v
+}
@@ -0,0 +1,206 @@
+package sendmsg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ProgressRow 发版进度表中的一行，对应一个服务
+type ProgressRow struct {
+	JobName       string
+	VersionNumber string
+	// Cluster/Namespace 发版目标，为空表示默认集群和命名空间
+	Cluster   string
+	Namespace string
+	// Stage 所属的发版阶段，为空表示不分阶段
+	Stage  string
+	Status string
+	Detail string
+}
+
+// 每种状态在进度表中的展示文字
+var statusText = map[string]string{
+	"pending":    "⏳ 等待中",
+	"rolling":    "🔄 滚动更新中",
+	"ready":      "✅ 已就绪",
+	"failed":     "❌ 失败",
+	"rolledback": "↩️ 已回滚",
+	"skipped":    "⏭️ 已跳过",
+}
+
+// BuildProgressCard 构建带有逐个服务进度表的发版卡片，update_multi 保证群里所有人看到的都是更新后的卡片
+func BuildProgressCard(instanceCode string, rows []ProgressRow, colors string) map[string]interface{} {
+	currentTime := time.Now().Format("2006-01-02 15:04:05")
+
+	elements := []interface{}{
+		map[string]interface{}{
+			"tag": "div",
+			"fields": []interface{}{
+				map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**审批单**\n" + instanceCode, "tag": "lark_md"}},
+				map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**更新时间**\n" + currentTime, "tag": "lark_md"}},
+			},
+		},
+		map[string]interface{}{"tag": "hr"},
+		progressColumns("**服务**", "**版本**", "**目标**", "**状态**"),
+	}
+
+	stages := stageNames(rows)
+	for i, row := range rows {
+		// 分阶段发版时每个阶段前加一行阶段名称和阶段状态
+		if len(stages) > 0 && (i == 0 || rows[i-1].Stage != row.Stage) {
+			elements = append(elements, map[string]interface{}{
+				"tag":     "markdown",
+				"content": fmt.Sprintf("**阶段 %d/%d: %s** %s", indexOf(stages, row.Stage)+1, len(stages), row.Stage, stageStatus(rows, row.Stage)),
+			})
+		}
+
+		status, ok := statusText[row.Status]
+		if !ok {
+			status = row.Status
+		}
+		elements = append(elements, progressColumns(row.JobName, row.VersionNumber, rowTarget(row), status))
+		if actions := progressActions(instanceCode, row); actions != nil {
+			elements = append(elements, actions)
+		}
+		if row.Detail != "" {
+			elements = append(elements, map[string]interface{}{
+				"tag": "note",
+				"elements": []interface{}{
+					map[string]interface{}{"tag": "plain_text", "content": fmt.Sprintf("%s: %s", row.JobName, row.Detail)},
+				},
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"config": map[string]bool{
+			"wide_screen_mode": true,
+			"update_multi":     true,
+		},
+		"header": map[string]interface{}{
+			"title": map[string]interface{}{
+				"content": "发版进度",
+				"tag":     "plain_text",
+			},
+			"template": colors,
+		},
+		"elements": elements,
+	}
+}
+
+// progressActions 生成一行服务的操作按钮，点击后由卡片回调处理；发版进行中只能查看 Pod
+func progressActions(instanceCode string, row ProgressRow) map[string]interface{} {
+	if row.VersionNumber == "" {
+		return nil
+	}
+
+	button := func(text, buttonType, action string) map[string]interface{} {
+		return map[string]interface{}{
+			"tag":  "button",
+			"text": map[string]interface{}{"tag": "plain_text", "content": text},
+			"type": buttonType,
+			"value": map[string]string{
+				"action":    action,
+				"instance":  instanceCode,
+				"job":       row.JobName,
+				"version":   row.VersionNumber,
+				"cluster":   row.Cluster,
+				"namespace": row.Namespace,
+			},
+		}
+	}
+
+	actions := []interface{}{button("查看 Pod", "default", "pods")}
+	if row.Status == "skipped" {
+		actions = append(actions, button("重试", "primary", "retry"))
+	} else if row.Status != "pending" && row.Status != "rolling" {
+		actions = append(actions, button("重试", "primary", "retry"), button("回滚", "danger", "rollback"))
+		if row.Status == "ready" && strings.HasSuffix(row.JobName, "-gray-level") {
+			actions = append(actions, button("转正", "primary", "promote"))
+		}
+	}
+
+	return map[string]interface{}{
+		"tag":     "action",
+		"actions": actions,
+	}
+}
+
+// stageNames 按出现顺序返回各行的发版阶段，所有行都没有阶段时返回 nil
+func stageNames(rows []ProgressRow) []string {
+	var names []string
+	for i, row := range rows {
+		if i == 0 || rows[i-1].Stage != row.Stage {
+			names = append(names, row.Stage)
+		}
+	}
+	if len(names) == 1 && names[0] == "" {
+		return nil
+	}
+	return names
+}
+
+// indexOf 返回 value 在切片中的位置
+func indexOf(values []string, value string) int {
+	for i, v := range values {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
+
+// stageStatus 汇总一个阶段内所有服务的状态
+func stageStatus(rows []ProgressRow, stage string) string {
+	counts := make(map[string]int)
+	total := 0
+	for _, row := range rows {
+		if row.Stage == stage {
+			counts[row.Status]++
+			total++
+		}
+	}
+	switch {
+	case counts["failed"] > 0:
+		return statusText["failed"]
+	case counts["skipped"] == total:
+		return statusText["skipped"]
+	case counts["pending"] == total:
+		return statusText["pending"]
+	case counts["ready"]+counts["rolledback"] == total:
+		return "✅ 已完成"
+	default:
+		return statusText["rolling"]
+	}
+}
+
+// rowTarget 展示发版目标，默认集群和命名空间显示为 "-"
+func rowTarget(row ProgressRow) string {
+	target := strings.Trim(row.Cluster+"/"+row.Namespace, "/")
+	if target == "" {
+		return "-"
+	}
+	return target
+}
+
+// progressColumns 用 column_set 拼出进度表的一行
+func progressColumns(cells ...string) map[string]interface{} {
+	columns := make([]interface{}, 0, len(cells))
+	for _, cell := range cells {
+		columns = append(columns, map[string]interface{}{
+			"tag":    "column",
+			"width":  "weighted",
+			"weight": 1,
+			"elements": []interface{}{
+				map[string]interface{}{"tag": "markdown", "content": cell},
+			},
+		})
+	}
+	return map[string]interface{}{
+		"tag":              "column_set",
+		"flex_mode":        "none",
+		"columns":          columns,
+		"background_style": "default",
+	}
+}
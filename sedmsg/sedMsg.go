@@ -1,11 +1,18 @@
 package sendmsg
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"regexp"
-	"strings"
+	"strconv"
+	"testapi/registry"
 	"time"
 )
 
@@ -19,13 +26,35 @@ const (
 	mydefaultapi = "https://open.feishu.cn/open-apis/bot/v2/hookxxxxxxx"
 )
 
+// 定义 webhook 签名密钥常量，机器人开启"签名校验"后填写，未开启留空
+const (
+	mydefaultsecret = ""
+)
+
+// 定义 群聊 chat_id 常量，发版进度卡片通过机器人发到群里，之后原地更新
+const (
+	mydefaultchat = ""
+)
+
+// Route 一个服务的通知路由，Webhook 用于一次性通知，ChatID 用于可以原地更新的发版卡片
+type Route struct {
+	// Type 通知渠道: feishu、dingtalk、wecom、slack、email，为空时为 feishu
+	Type    string
+	Webhook string
+	// Secret 机器人安全设置中的签名密钥，为空时不签名，飞书和钉钉支持
+	Secret string
+	ChatID string
+	// To 邮件收件人，仅 email 类型使用
+	To []string
+}
+
 // 定义一个不可变的 map
-var APIMap = map[string]string{
-	mydefault: mydefaultapi,
+var APIMap = map[string]Route{
+	mydefault: {Webhook: mydefaultapi, Secret: mydefaultsecret, ChatID: mydefaultchat},
 }
 
 // 使用正则表达式判断 jobName 是否包含任何一个常量
-func regexpString(jobName string) string {
+func regexpString(jobName string) Route {
 	for k := range APIMap {
 		// 对常量进行转义
 		escapedConstant := regexp.QuoteMeta(k)
@@ -35,82 +64,137 @@ func regexpString(jobName string) string {
 		re := regexp.MustCompile(pattern)
 		// 检查 jobName 是否匹配
 		if match := re.FindString(jobName); match != "" {
-			// 如果匹配，返回对应的 API URL
+			// 如果匹配，返回对应的路由
 			return APIMap[k]
 		}
 	}
-	// 如果没有匹配到任何常量，返回默认的 API URL
-	return mydefaultapi
+	// 如果没有匹配到任何常量，返回默认的路由
+	return APIMap[mydefault]
+}
+
+// ResolveRoute 返回 jobName 对应的通知路由，服务登记中指定了路由时优先使用
+func ResolveRoute(jobName string) Route {
+	if service, ok := registry.Lookup(jobName); ok && service.Route != "" {
+		if route, ok := APIMap[service.Route]; ok {
+			return route
+		}
+	}
+	return regexpString(jobName)
+}
+
+// genSign 计算飞书自定义机器人的签名: base64(HmacSHA256(timestamp + "\n" + secret, ""))
+func genSign(secret string, timestamp int64) string {
+	stringToSign := fmt.Sprintf("%d\n%s", timestamp, secret)
+	h := hmac.New(sha256.New, []byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
 
-func sendFeishuMsg(apiUrl, contentType, data string) error {
-	req, err := http.NewRequest("POST", apiUrl, strings.NewReader(data))
+// webhookResponse 飞书自定义机器人的响应，新版返回 code/msg，旧版返回 StatusCode/StatusMessage
+type webhookResponse struct {
+	Code          int    `json:"code"`
+	Msg           string `json:"msg"`
+	StatusCode    int    `json:"StatusCode"`
+	StatusMessage string `json:"StatusMessage"`
+}
+
+// postJSON 以 JSON 格式 POST 到 webhook，HTTP 状态码不是 200 时返回错误，否则返回响应体
+func postJSON(ctx context.Context, url string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("json.Marshal failed: %w", err)
 	}
-	req.Header.Set("Content-Type", contentType)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response failed: %w", err)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("HTTP response status: %s", resp.Status)
+		return nil, fmt.Errorf("HTTP response status: %s, body: %s", resp.Status, string(body))
 	}
-	return nil
+	return body, nil
 }
 
-func SendInteractiveMsg(message, jobName, colors string) {
-	apiUrl := regexpString(jobName)
-	contentType := "application/json"
+// sendFeishuMsg 发送 webhook 消息，路由配置了密钥时附带 timestamp 和 sign
+func sendFeishuMsg(ctx context.Context, route Route, message map[string]interface{}) error {
+	if route.Secret != "" {
+		timestamp := time.Now().Unix()
+		message["timestamp"] = strconv.FormatInt(timestamp, 10)
+		message["sign"] = genSign(route.Secret, timestamp)
+	}
+
+	body, err := postJSON(ctx, route.Webhook, message)
+	if err != nil {
+		return err
+	}
+
+	// 飞书签名错误、关键词不匹配等情况同样返回 HTTP 200，需要检查响应体中的 code
+	var response webhookResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("decode response failed: %w, body: %s", err, string(body))
+	}
+	if response.Code != 0 {
+		return fmt.Errorf("feishu webhook error, code: %d, msg: %s", response.Code, response.Msg)
+	}
+	if response.StatusCode != 0 {
+		return fmt.Errorf("feishu webhook error, code: %d, msg: %s", response.StatusCode, response.StatusMessage)
+	}
+	return nil
+}
 
+// BuildCard 构建发版通知卡片，webhook 和机器人单聊消息共用同一个卡片结构
+func BuildCard(message, colors string) map[string]interface{} {
 	currentTime := time.Now().Format("2006-01-02 15:04:05")
 
-	cardMessage := map[string]interface{}{
-		"msg_type": "interactive",
-		"card": map[string]interface{}{
-			"config": map[string]bool{
-				"wide_screen_mode": true,
-			},
-			"header": map[string]interface{}{
-				"title": map[string]interface{}{
-					"content": "发版通知",
-					"tag":     "plain_text",
-				},
-				//"template": "blue",
-				//green
-				//red
-				"template": colors,
+	return map[string]interface{}{
+		"config": map[string]bool{
+			"wide_screen_mode": true,
+		},
+		"header": map[string]interface{}{
+			"title": map[string]interface{}{
+				"content": "发版通知",
+				"tag":     "plain_text",
 			},
-			"elements": []interface{}{
-				map[string]interface{}{
-					"tag": "div",
-					"fields": []interface{}{
-						//map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**告警级别**", "tag": "lark_md"}},
-						//map[string]interface{}{"is_short": false, "text": map[string]interface{}{"content": alertLevel, "tag": "lark_md"}},
-						//map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**资源**", "tag": "lark_md"}},
-						//map[string]interface{}{"is_short": false, "text": map[string]interface{}{"content": resource, "tag": "lark_md"}},
-						map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**时间**", "tag": "lark_md"}},
-						map[string]interface{}{"is_short": false, "text": map[string]interface{}{"content": currentTime, "tag": "lark_md"}},
-						map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**详情**", "tag": "lark_md"}},
-						map[string]interface{}{"is_short": false, "text": map[string]interface{}{"content": message + "\n", "tag": "lark_md"}},
-					},
+			//"template": "blue",
+			//green
+			//red
+			"template": colors,
+		},
+		"elements": []interface{}{
+			map[string]interface{}{
+				"tag": "div",
+				"fields": []interface{}{
+					//map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**告警级别**", "tag": "lark_md"}},
+					//map[string]interface{}{"is_short": false, "text": map[string]interface{}{"content": alertLevel, "tag": "lark_md"}},
+					//map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**资源**", "tag": "lark_md"}},
+					//map[string]interface{}{"is_short": false, "text": map[string]interface{}{"content": resource, "tag": "lark_md"}},
+					map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**时间**", "tag": "lark_md"}},
+					map[string]interface{}{"is_short": false, "text": map[string]interface{}{"content": currentTime, "tag": "lark_md"}},
+					map[string]interface{}{"is_short": true, "text": map[string]interface{}{"content": "**详情**", "tag": "lark_md"}},
+					map[string]interface{}{"is_short": false, "text": map[string]interface{}{"content": message + "\n", "tag": "lark_md"}},
 				},
 			},
 		},
 	}
+}
 
-	sendData, err := json.Marshal(cardMessage)
-	if err != nil {
-		fmt.Printf("json.Marshal failed, err: %v\n", err)
-		return
-	}
-
-	if err := sendFeishuMsg(apiUrl, contentType, string(sendData)); err != nil {
-		fmt.Printf("post failed, err: %v\n", err)
-	} else {
-		fmt.Println("告警已成功发送")
-	}
+// SendInteractiveMsg 按颜色发送一条发版通知，实际发送渠道由服务的路由类型决定，links 渲染为按钮或链接
+func SendInteractiveMsg(message, jobName, colors string, links ...Link) {
+	Notify(Event{
+		Service: jobName,
+		Status:  colorStatus(colors),
+		Message: message,
+		Links:   links,
+	})
 }
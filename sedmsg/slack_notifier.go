@@ -0,0 +1,70 @@
+package sendmsg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// SlackNotifier 通过 Slack Incoming Webhook 发送 Block Kit 消息
+type SlackNotifier struct {
+	Webhook string
+}
+
+// slackEmoji 事件状态对应的 emoji
+var slackEmoji = map[string]string{
+	StatusPending:    ":hourglass:",
+	StatusRolling:    ":arrows_counterclockwise:",
+	StatusReady:      ":white_check_mark:",
+	StatusFailed:     ":x:",
+	StatusRolledBack: ":leftwards_arrow_with_hook:",
+}
+
+// Notify 发送 Block Kit 消息，附带的链接渲染为按钮
+func (n *SlackNotifier) Notify(ctx context.Context, event Event) error {
+	title := statusTitle(event.Status)
+	if emoji, ok := slackEmoji[event.Status]; ok {
+		title = emoji + " " + title
+	}
+
+	// Slack mrkdwn 的粗体是单个星号
+	text := strings.ReplaceAll(eventLines(event), "**", "*")
+
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": title},
+		},
+		map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{"type": "mrkdwn", "text": text},
+		},
+	}
+	if len(event.Links) > 0 {
+		var elements []interface{}
+		for _, link := range event.Links {
+			elements = append(elements, map[string]interface{}{
+				"type": "button",
+				"text": map[string]interface{}{"type": "plain_text", "text": link.Title},
+				"url":  link.URL,
+			})
+		}
+		blocks = append(blocks, map[string]interface{}{
+			"type":     "actions",
+			"elements": elements,
+		})
+	}
+
+	// Slack 成功时返回纯文本 ok，失败时返回非 200 状态码
+	body, err := postJSON(ctx, n.Webhook, map[string]interface{}{
+		"text":   fmt.Sprintf("%s %s %s", title, event.Service, event.Version),
+		"blocks": blocks,
+	})
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != "ok" {
+		return fmt.Errorf("slack webhook error: %s", string(body))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package sendmsg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		response string
+		wantErr  bool
+	}{
+		{name: "成功", response: "ok"},
+		{name: "webhook 失效", status: http.StatusNotFound, response: "no_service", wantErr: true},
+		{name: "响应不是 ok", response: "invalid_payload", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			server, requests := newWebhook(t, status, tt.response)
+			n := &SlackNotifier{Webhook: server.URL}
+
+			err := n.Notify(context.Background(), testEvent)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(*requests) != 1 {
+				t.Fatalf("收到 %d 个请求, want 1", len(*requests))
+			}
+			body := (*requests)[0].Body
+			if got := field(t, body, "blocks", 0, "text", "text"); got != ":white_check_mark: 发版成功" {
+				t.Errorf("header = %v", got)
+			}
+			if text := field(t, body, "blocks", 1, "text", "text").(string); !strings.Contains(text, "*服务*: order-api") {
+				t.Errorf("section 缺少服务名: %s", text)
+			}
+			elements := field(t, body, "blocks", 2, "elements").([]interface{})
+			if len(elements) != len(testEvent.Links) {
+				t.Fatalf("按钮数 = %d, want %d", len(elements), len(testEvent.Links))
+			}
+			if got := field(t, elements[1], "url"); got != testEvent.Links[1].URL {
+				t.Errorf("url = %v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package sendmsg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// WeComNotifier 通过企业微信群机器人发送 markdown 消息
+type WeComNotifier struct {
+	Webhook string
+}
+
+// weComResponse 企业微信机器人响应，出错时同样返回 HTTP 200
+type weComResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// weComColors 企业微信 markdown 只支持 info、comment、warning 三种颜色
+var weComColors = map[string]string{
+	StatusReady:      "info",
+	StatusFailed:     "warning",
+	StatusRolledBack: "warning",
+}
+
+// Notify 发送 markdown 消息，附带的链接渲染为 markdown 链接
+func (n *WeComNotifier) Notify(ctx context.Context, event Event) error {
+	color, ok := weComColors[event.Status]
+	if !ok {
+		color = "comment"
+	}
+
+	var content strings.Builder
+	content.WriteString(fmt.Sprintf("### <font color=\"%s\">%s</font>\n", color, statusTitle(event.Status)))
+	for _, line := range strings.Split(strings.TrimSpace(eventLines(event)), "\n") {
+		content.WriteString("> " + line + "\n")
+	}
+	for _, link := range event.Links {
+		content.WriteString(fmt.Sprintf("[%s](%s)\n", link.Title, link.URL))
+	}
+
+	body, err := postJSON(ctx, n.Webhook, map[string]interface{}{
+		"msgtype":  "markdown",
+		"markdown": map[string]string{"content": content.String()},
+	})
+	if err != nil {
+		return err
+	}
+
+	var response weComResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("decode response failed: %w, body: %s", err, string(body))
+	}
+	if response.ErrCode != 0 {
+		return fmt.Errorf("wecom webhook error, code: %d, msg: %s", response.ErrCode, response.ErrMsg)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package sendmsg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWeComNotifier(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    Event
+		status   int
+		response string
+		wantErr  bool
+		want     []string
+	}{
+		{
+			name:     "成功",
+			event:    testEvent,
+			response: `{"errcode":0,"errmsg":"ok"}`,
+			want:     []string{`<font color="info">发版成功</font>`, "> **服务**: order-api", "[查看审批单](https://approval.example.com/i/1)"},
+		},
+		{
+			name:     "失败用 warning 颜色",
+			event:    Event{Service: "order-api", Status: StatusFailed, Message: "超时"},
+			response: `{"errcode":0,"errmsg":"ok"}`,
+			want:     []string{`<font color="warning">发版失败</font>`},
+		},
+		{name: "业务错误", event: testEvent, response: `{"errcode":93000,"errmsg":"invalid webhook url"}`, wantErr: true},
+		{name: "HTTP 错误", event: testEvent, status: http.StatusBadGateway, response: `bad gateway`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			server, requests := newWebhook(t, status, tt.response)
+			n := &WeComNotifier{Webhook: server.URL}
+
+			err := n.Notify(context.Background(), tt.event)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(*requests) != 1 {
+				t.Fatalf("收到 %d 个请求, want 1", len(*requests))
+			}
+			body := (*requests)[0].Body
+			if got := field(t, body, "msgtype"); got != "markdown" {
+				t.Errorf("msgtype = %v", got)
+			}
+			content := field(t, body, "markdown", "content").(string)
+			for _, want := range tt.want {
+				if !strings.Contains(content, want) {
+					t.Errorf("content 缺少 %q:\n%s", want, content)
+				}
+			}
+		})
+	}
+}
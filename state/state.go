@@ -0,0 +1,424 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"testapi/store"
+	"time"
+)
+
+// 所有审批单的发版状态保存在存储的这个集合中，审批单号 -> Release 的 JSON
+const stateKey = "release:state"
+
+// 没有结束的审批单保存在存储的这个集合中，审批单号 -> 审批来源；轮询时只读取这个集合查找中断的发版，
+// 审批单结束时删除
+const activeKey = "release:active"
+
+// activeMigratedKey 这个 key 写入 activeKey 后，说明升级前没有结束的审批单已经全部加入 activeKey
+const activeMigratedKey = "~migrated"
+
+// Phase 发版状态；审批单和其中每个服务共用一套状态：
+// received -> validated -> deploying -> verifying -> succeeded/failed/rolled-back
+type Phase string
+
+const (
+	PhaseReceived  Phase = "received"
+	PhaseValidated Phase = "validated"
+	PhaseDeploying Phase = "deploying"
+	PhaseVerifying Phase = "verifying"
+	PhaseSucceeded Phase = "succeeded"
+	PhaseFailed    Phase = "failed"
+	// PhaseRolledBack 服务发版后被回滚
+	PhaseRolledBack Phase = "rolled-back"
+	// PhaseSkipped 服务因前序阶段失败没有执行
+	PhaseSkipped Phase = "skipped"
+	// PhaseScheduled 审批单在排期中等待发版
+	PhaseScheduled Phase = "scheduled"
+	// PhaseRejected 审批单没有通过清单校验、发版策略或冻结检查
+	PhaseRejected Phase = "rejected"
+)
+
+// Terminal 是否为结束状态，结束后不会再自动执行
+func (p Phase) Terminal() bool {
+	switch p {
+	case PhaseSucceeded, PhaseFailed, PhaseRolledBack, PhaseSkipped, PhaseRejected:
+		return true
+	}
+	return false
+}
+
+// Transition 一次状态变化
+type Transition struct {
+	Phase Phase     `json:"phase"`
+	At    time.Time `json:"at"`
+	Error string    `json:"error,omitempty"`
+}
+
+// Service 审批单中一个服务的发版状态
+type Service struct {
+	JobName   string       `json:"jobName"`
+	Version   string       `json:"version"`
+	Cluster   string       `json:"cluster,omitempty"`
+	Namespace string       `json:"namespace,omitempty"`
+	Stage     string       `json:"stage,omitempty"`
+	Phase     Phase        `json:"phase"`
+	Error     string       `json:"error,omitempty"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+	History   []Transition `json:"history"`
+}
+
+// Release 一个审批单的发版状态
+type Release struct {
+	InstanceCode string       `json:"instanceCode"`
+	Source       string       `json:"source"`
+	ApprovalCode string       `json:"approvalCode,omitempty"`
+	Phase        Phase        `json:"phase"`
+	Error        string       `json:"error,omitempty"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	UpdatedAt    time.Time    `json:"updatedAt"`
+	History      []Transition `json:"history"`
+	Services     []*Service   `json:"services"`
+
+	// Applicant/Approvers 申请人和审批人的 user_id，没有 user_id 时为 open_id
+	Applicant string   `json:"applicant,omitempty"`
+	Approvers []string `json:"approvers,omitempty"`
+	// Environment 发版环境，卡片按钮按环境检查操作权限
+	Environment string `json:"environment,omitempty"`
+
+	mu sync.Mutex
+}
+
+var (
+	mu sync.Mutex
+	// releases 已经加载过的发版状态，同一个审批单在进程内只有一个对象
+	releases = map[string]*Release{}
+)
+
+// Get 获取审批单的发版状态，内存中没有时从存储加载，不存在时返回 nil
+func Get(instanceCode string) (*Release, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if r, ok := releases[instanceCode]; ok {
+		return r, nil
+	}
+
+	data, ok, err := store.Default().Get(context.TODO(), stateKey, instanceCode)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	r := &Release{}
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		return nil, fmt.Errorf("解析审批单 %s 的发版状态失败: %w", instanceCode, err)
+	}
+	releases[instanceCode] = r
+	return r, nil
+}
+
+// Load 从存储读取审批单最新的发版状态，不使用也不更新进程内的缓存，不存在时返回 nil；
+// 查询接口使用，发版可能正在其他副本上执行
+func Load(instanceCode string) (*Release, error) {
+	data, ok, err := store.Default().Get(context.TODO(), stateKey, instanceCode)
+	if err != nil || !ok {
+		return nil, err
+	}
+	r := &Release{}
+	if err := json.Unmarshal([]byte(data), r); err != nil {
+		return nil, fmt.Errorf("解析审批单 %s 的发版状态失败: %w", instanceCode, err)
+	}
+	return r, nil
+}
+
+// Begin 获取审批单的发版状态，不存在时创建为 received；读取失败时返回错误，不会用新的记录覆盖存储中的状态，
+// 调用方应当跳过这个审批单，下次轮询再处理
+func Begin(source, instanceCode string) (*Release, error) {
+	r, err := Get(instanceCode)
+	if err != nil {
+		return nil, fmt.Errorf("加载审批单 %s 的发版状态失败: %w", instanceCode, err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if r != nil {
+		return r, nil
+	}
+	if existing, ok := releases[instanceCode]; ok {
+		return existing, nil
+	}
+	now := time.Now()
+	r = &Release{
+		InstanceCode: instanceCode,
+		Source:       source,
+		Phase:        PhaseReceived,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		History:      []Transition{{Phase: PhaseReceived, At: now}},
+	}
+	if err := r.save(); err != nil {
+		return nil, err
+	}
+	releases[instanceCode] = r
+	return r, nil
+}
+
+// Interrupted 从没有结束的审批单中找出发版过程中进程退出的审批单，不包括排期中的
+func Interrupted() ([]*Release, error) {
+	if err := migrateActive(); err != nil {
+		return nil, err
+	}
+	entries, err := store.Default().List(context.TODO(), activeKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var interrupted []*Release
+	for code := range entries {
+		if code == activeMigratedKey {
+			continue
+		}
+		current, err := Get(code)
+		if err != nil {
+			log.Printf("加载审批单 %s 的发版状态失败: %v", code, err)
+			continue
+		}
+		if current == nil || current.CurrentPhase().Terminal() {
+			// 状态已经结束但没有从集合中删除，这里补删
+			if err := store.Default().Delete(context.TODO(), activeKey, code); err != nil {
+				log.Printf("删除审批单 %s 的未结束标记失败: %v", code, err)
+			}
+			continue
+		}
+		if current.CurrentPhase() == PhaseScheduled {
+			continue
+		}
+		interrupted = append(interrupted, current)
+	}
+	sort.Slice(interrupted, func(i, j int) bool { return interrupted[i].CreatedAt.Before(interrupted[j].CreatedAt) })
+	return interrupted, nil
+}
+
+// migrateActive 升级前没有 activeKey，第一次使用时扫描一遍所有发版状态，把没有结束的加入 activeKey
+func migrateActive() error {
+	ctx := context.TODO()
+	if _, ok, err := store.Default().Get(ctx, activeKey, activeMigratedKey); err != nil || ok {
+		return err
+	}
+	entries, err := store.Default().List(ctx, stateKey)
+	if err != nil {
+		return err
+	}
+	for code, data := range entries {
+		var r Release
+		if err := json.Unmarshal([]byte(data), &r); err != nil {
+			log.Printf("解析审批单 %s 的发版状态失败: %v", code, err)
+			continue
+		}
+		if r.Phase.Terminal() {
+			continue
+		}
+		if err := store.Default().Put(ctx, activeKey, code, r.Source); err != nil {
+			return fmt.Errorf("记录审批单 %s 未结束失败: %w", code, err)
+		}
+	}
+	return store.Default().Put(ctx, activeKey, activeMigratedKey, time.Now().Format(time.RFC3339))
+}
+
+// List 读取所有审批单的发版状态，按创建时间从新到旧排列；返回的是存储中的副本，只用于查询
+func List() ([]*Release, error) {
+	entries, err := store.Default().List(context.TODO(), stateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]*Release, 0, len(entries))
+	for code, data := range entries {
+		r := &Release{}
+		if err := json.Unmarshal([]byte(data), r); err != nil {
+			log.Printf("解析审批单 %s 的发版状态失败: %v", code, err)
+			continue
+		}
+		all = append(all, r)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].CreatedAt.After(all[j].CreatedAt) })
+	return all, nil
+}
+
+// JSON 序列化当前状态，持有锁，避免和发版过程中的状态变化同时进行
+func (r *Release) JSON() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return json.Marshal(r)
+}
+
+// Transition 修改审批单的状态并保存，返回保存失败的错误
+func (r *Release) Transition(phase Phase, errMsg string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.Phase = phase
+	r.Error = errMsg
+	r.UpdatedAt = now
+	r.History = append(r.History, Transition{Phase: phase, At: now, Error: errMsg})
+	return r.save()
+}
+
+// SetRequester 记录审批定义、申请人和审批人并保存，用于审计记录和执行前的策略检查
+func (r *Release) SetRequester(approvalCode, applicant string, approvers []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ApprovalCode = approvalCode
+	r.Applicant = applicant
+	r.Approvers = approvers
+	return r.save()
+}
+
+// Requester 返回审批定义、申请人和审批人
+func (r *Release) Requester() (approvalCode, applicant string, approvers []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.ApprovalCode, r.Applicant, append([]string(nil), r.Approvers...)
+}
+
+// SetEnvironment 记录发版环境并保存
+func (r *Release) SetEnvironment(environment string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Environment = environment
+	return r.save()
+}
+
+// CurrentEnvironment 返回发版环境
+func (r *Release) CurrentEnvironment() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Environment
+}
+
+// ServiceNames 返回审批单中登记的服务名，去重，按登记顺序
+func (r *Release) ServiceNames() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.Services))
+	seen := make(map[string]bool, len(r.Services))
+	for _, s := range r.Services {
+		if !seen[s.JobName] {
+			seen[s.JobName] = true
+			names = append(names, s.JobName)
+		}
+	}
+	return names
+}
+
+// CurrentPhase 返回审批单当前的状态
+func (r *Release) CurrentPhase() Phase {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Phase
+}
+
+// Track 登记审批单中要发版的服务，已经登记过的保留原来的状态
+func (r *Release) Track(jobName, version, cluster, namespace, stage string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s := r.find(jobName, cluster, namespace); s != nil {
+		s.Version = version
+		s.Stage = stage
+		return nil
+	}
+	now := time.Now()
+	r.Services = append(r.Services, &Service{
+		JobName:   jobName,
+		Version:   version,
+		Cluster:   cluster,
+		Namespace: namespace,
+		Stage:     stage,
+		Phase:     PhaseValidated,
+		UpdatedAt: now,
+		History:   []Transition{{Phase: PhaseValidated, At: now}},
+	})
+	return r.save()
+}
+
+// ServicePhase 返回服务当前的状态，没有登记时返回空字符串
+func (r *Release) ServicePhase(jobName, cluster, namespace string) Phase {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s := r.find(jobName, cluster, namespace); s != nil {
+		return s.Phase
+	}
+	return ""
+}
+
+// ServiceTransition 修改服务的状态并保存，服务没有登记时忽略
+func (r *Release) ServiceTransition(jobName, cluster, namespace string, phase Phase, errMsg string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.find(jobName, cluster, namespace)
+	if s == nil {
+		return nil
+	}
+	now := time.Now()
+	s.Phase = phase
+	s.Error = errMsg
+	s.UpdatedAt = now
+	s.History = append(s.History, Transition{Phase: phase, At: now, Error: errMsg})
+	r.UpdatedAt = now
+	return r.save()
+}
+
+// AllSucceeded 登记的服务是否都已经发版成功
+func (r *Release) AllSucceeded() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.Services {
+		if s.Phase != PhaseSucceeded {
+			return false
+		}
+	}
+	return len(r.Services) > 0
+}
+
+// find 按服务名和发版目标查找服务，调用方需要持有 r.mu
+func (r *Release) find(jobName, cluster, namespace string) *Service {
+	for _, s := range r.Services {
+		if s.JobName == jobName && s.Cluster == cluster && s.Namespace == namespace {
+			return s
+		}
+	}
+	return nil
+}
+
+// save 保存到存储并更新未结束的审批单集合，失败时记录日志并返回错误，调用方需要持有 r.mu
+func (r *Release) save() error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		err = fmt.Errorf("序列化审批单 %s 的发版状态失败: %w", r.InstanceCode, err)
+		log.Print(err)
+		return err
+	}
+	ctx := context.TODO()
+	// 先标记为未结束再保存状态，中间失败时多出的标记会在查找中断的发版时删除
+	if !r.Phase.Terminal() {
+		err = store.Default().Put(ctx, activeKey, r.InstanceCode, r.Source)
+	}
+	if err == nil {
+		err = store.Default().Put(ctx, stateKey, r.InstanceCode, string(data))
+	}
+	if err == nil && r.Phase.Terminal() {
+		// 状态已经保存，标记没有删除时查找中断的发版会补删，不作为保存失败
+		if err := store.Default().Delete(ctx, activeKey, r.InstanceCode); err != nil {
+			log.Printf("删除审批单 %s 的未结束标记失败: %v", r.InstanceCode, err)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("保存审批单 %s 的发版状态失败: %w", r.InstanceCode, err)
+		log.Print(err)
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// 去重标记保存在这个 bucket 中，审批单号 -> 标记时间
+const processedBucket = "processed"
+
+// 审批单认领保存在这个 bucket 中，审批单号 -> claim 的 JSON
+const claimBucket = "claims"
+
+// claim 一次认领
+type claim struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+// 过期的认领至少间隔这么久清理一次
+const claimPurgeInterval = time.Minute
+
+// boltStore 用本地 bbolt 文件保存，集合对应 bucket；同一个文件只能被一个进程打开
+type boltStore struct {
+	db *bolt.DB
+	// purgedAt 上次清理过期认领的时间，只在写事务中读写
+	purgedAt time.Time
+}
+
+// OpenBolt 打开或创建数据文件，文件被其他进程占用时等待 5 秒后返回错误
+func OpenBolt(path string) (StateStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开状态文件 %s 失败: %w", path, err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Processed(ctx context.Context, instanceCode string) (bool, error) {
+	_, ok, err := s.Get(ctx, processedBucket, instanceCode)
+	return ok, err
+}
+
+func (s *boltStore) MarkProcessed(ctx context.Context, instanceCode string) error {
+	return s.Put(ctx, processedBucket, instanceCode, time.Now().Format(time.RFC3339))
+}
+
+// Claim 在一个写事务中检查和写入，bbolt 的写事务是串行的
+func (s *boltStore) Claim(_ context.Context, instanceCode, owner string, ttl time.Duration) (bool, error) {
+	claimed := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(claimBucket))
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		if now.Sub(s.purgedAt) > claimPurgeInterval {
+			if err := purgeClaims(b, now); err != nil {
+				return err
+			}
+			s.purgedAt = now
+		}
+		if v := b.Get([]byte(instanceCode)); v != nil {
+			var existing claim
+			if err := json.Unmarshal(v, &existing); err == nil && now.Before(existing.Expires) {
+				return nil
+			}
+		}
+		data, err := json.Marshal(claim{Owner: owner, Expires: now.Add(ttl)})
+		if err != nil {
+			return err
+		}
+		claimed = true
+		return b.Put([]byte(instanceCode), data)
+	})
+	if err != nil {
+		return false, fmt.Errorf("认领审批单 %s 失败: %w", instanceCode, err)
+	}
+	return claimed, nil
+}
+
+// purgeClaims 删除已经过期的认领，避免卡片回调 nonce 等一次性的认领一直累积
+func purgeClaims(b *bolt.Bucket, now time.Time) error {
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; {
+		var existing claim
+		if err := json.Unmarshal(v, &existing); err == nil && now.Before(existing.Expires) {
+			k, v = c.Next()
+			continue
+		}
+		key := append([]byte{}, k...)
+		if err := c.Delete(); err != nil {
+			return err
+		}
+		// 删除后游标的位置不确定，重新定位到被删除项之后
+		k, v = c.Seek(key)
+	}
+	return nil
+}
+
+func (s *boltStore) Get(_ context.Context, collection, key string) (string, bool, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		// bbolt 返回的切片只在事务内有效
+		if v := b.Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("读取 %s/%s 失败: %w", collection, key, err)
+	}
+	return string(value), value != nil, nil
+}
+
+func (s *boltStore) Put(_ context.Context, collection, key, value string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		return fmt.Errorf("写入 %s/%s 失败: %w", collection, key, err)
+	}
+	return nil
+}
+
+// Create 在一个写事务中检查和写入
+func (s *boltStore) Create(_ context.Context, collection, key, value string) (bool, error) {
+	created := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(collection))
+		if err != nil {
+			return err
+		}
+		if b.Get([]byte(key)) != nil {
+			return nil
+		}
+		created = true
+		return b.Put([]byte(key), []byte(value))
+	})
+	if err != nil {
+		return false, fmt.Errorf("写入 %s/%s 失败: %w", collection, key, err)
+	}
+	return created, nil
+}
+
+func (s *boltStore) Delete(_ context.Context, collection, key string) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+	if err != nil {
+		return fmt.Errorf("删除 %s/%s 失败: %w", collection, key, err)
+	}
+	return nil
+}
+
+func (s *boltStore) List(_ context.Context, collection string) (map[string]string, error) {
+	result := map[string]string{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(collection))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			result[string(k)] = string(v)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("读取 %s 失败: %w", collection, err)
+	}
+	return result, nil
+}
+
+// Ping 本地文件打开后一直可用，只检查是否已经关闭
+func (s *boltStore) Ping(context.Context) error {
+	return s.db.View(func(*bolt.Tx) error { return nil })
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
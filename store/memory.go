@@ -0,0 +1,116 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memoryStore 只保存在内存中，进程退出后丢失，用于本地调试和测试
+type memoryStore struct {
+	mu          sync.Mutex
+	processed   map[string]bool
+	claims      map[string]time.Time // 审批单号 -> 认领过期时间
+	purgedAt    time.Time            // 上次清理过期认领的时间
+	collections map[string]map[string]string
+}
+
+// NewMemory 创建内存存储
+func NewMemory() StateStore {
+	return &memoryStore{
+		processed:   map[string]bool{},
+		claims:      map[string]time.Time{},
+		collections: map[string]map[string]string{},
+	}
+}
+
+func (s *memoryStore) Processed(_ context.Context, instanceCode string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.processed[instanceCode], nil
+}
+
+func (s *memoryStore) MarkProcessed(_ context.Context, instanceCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processed[instanceCode] = true
+	return nil
+}
+
+func (s *memoryStore) Claim(_ context.Context, instanceCode, _ string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.purgedAt) > claimPurgeInterval {
+		for code, expires := range s.claims {
+			if !now.Before(expires) {
+				delete(s.claims, code)
+			}
+		}
+		s.purgedAt = now
+	}
+	if expires, ok := s.claims[instanceCode]; ok && now.Before(expires) {
+		return false, nil
+	}
+	s.claims[instanceCode] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *memoryStore) Get(_ context.Context, collection, key string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.collections[collection][key]
+	return value, ok, nil
+}
+
+func (s *memoryStore) Put(_ context.Context, collection, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.collections[collection]
+	if !ok {
+		c = map[string]string{}
+		s.collections[collection] = c
+	}
+	c[key] = value
+	return nil
+}
+
+func (s *memoryStore) Create(_ context.Context, collection, key, value string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.collections[collection]
+	if !ok {
+		c = map[string]string{}
+		s.collections[collection] = c
+	}
+	if _, ok := c[key]; ok {
+		return false, nil
+	}
+	c[key] = value
+	return true, nil
+}
+
+func (s *memoryStore) Delete(_ context.Context, collection, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.collections[collection], key)
+	return nil
+}
+
+func (s *memoryStore) List(_ context.Context, collection string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make(map[string]string, len(s.collections[collection]))
+	for k, v := range s.collections[collection] {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (s *memoryStore) Ping(context.Context) error {
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	myredis "testapi/redis"
+	"time"
+)
+
+// 审批单认领的键前缀，后面是审批单号
+const claimPrefix = "release:claim:"
+
+// redisStore 用 Redis 保存，集合对应 Redis 哈希；去重标记沿用以审批单号为键的字符串
+type redisStore struct{}
+
+// NewRedis 使用 myredis 的默认客户端，连接由 myredis.Init 配置和关闭
+func NewRedis() StateStore {
+	return redisStore{}
+}
+
+func (redisStore) Processed(ctx context.Context, instanceCode string) (bool, error) {
+	return myredis.Default().Exists(ctx, instanceCode)
+}
+
+func (redisStore) MarkProcessed(ctx context.Context, instanceCode string) error {
+	return myredis.Default().Set(ctx, instanceCode, "123", 0)
+}
+
+func (redisStore) Claim(ctx context.Context, instanceCode, owner string, ttl time.Duration) (bool, error) {
+	return myredis.Default().SetNX(ctx, claimPrefix+instanceCode, owner, ttl)
+}
+
+func (redisStore) Get(ctx context.Context, collection, key string) (string, bool, error) {
+	return myredis.Default().HGet(ctx, collection, key)
+}
+
+func (redisStore) Put(ctx context.Context, collection, key, value string) error {
+	return myredis.Default().HSet(ctx, collection, key, value)
+}
+
+func (redisStore) Create(ctx context.Context, collection, key, value string) (bool, error) {
+	return myredis.Default().HSetNX(ctx, collection, key, value)
+}
+
+func (redisStore) Delete(ctx context.Context, collection, key string) error {
+	return myredis.Default().HDel(ctx, collection, key)
+}
+
+func (redisStore) List(ctx context.Context, collection string) (map[string]string, error) {
+	return myredis.Default().HGetAll(ctx, collection)
+}
+
+func (redisStore) Ping(ctx context.Context) error {
+	return myredis.Default().Ping(ctx)
+}
+
+// Close 不关闭 Redis 客户端，客户端可能还有其他用途
+func (redisStore) Close() error {
+	return nil
+}
@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 存储后端
+const (
+	BackendRedis  = "redis"
+	BackendBolt   = "bolt"
+	BackendMemory = "memory"
+)
+
+// StateStore 审批单去重标记和发版状态的存储，实现需要可以并发使用。
+// 记录按集合保存，集合名如 release:state，集合内为 key -> value
+type StateStore interface {
+	// Processed 审批单是否已经处理过
+	Processed(ctx context.Context, instanceCode string) (bool, error)
+	// MarkProcessed 标记审批单已经处理过，之后轮询到时跳过
+	MarkProcessed(ctx context.Context, instanceCode string) error
+	// Claim 原子地认领审批单，ttl 内其他副本（包括自己）再认领都会失败，用于多副本时只有一个副本处理同一个审批单；
+	// 过期的认领会被删除，不会一直累积
+	Claim(ctx context.Context, instanceCode, owner string, ttl time.Duration) (bool, error)
+
+	// Get 读取集合中的一条记录，不存在时返回 false
+	Get(ctx context.Context, collection, key string) (string, bool, error)
+	// Put 写入集合中的一条记录
+	Put(ctx context.Context, collection, key, value string) error
+	// Create 原子地写入集合中的一条记录，记录已经存在时不覆盖并返回 false，用于只能写一次的记录
+	Create(ctx context.Context, collection, key, value string) (bool, error)
+	// Delete 删除集合中的记录，不存在时不报错
+	Delete(ctx context.Context, collection, key string) error
+	// List 读取整个集合
+	List(ctx context.Context, collection string) (map[string]string, error)
+
+	// Ping 检查存储是否可用，用于健康检查
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Config 存储配置
+type Config struct {
+	// Backend redis、bolt 或 memory，为空时为 redis
+	Backend string
+	// Path bolt 的数据文件路径
+	Path string
+}
+
+// DefaultConfig 没有任何环境变量时的存储配置，Redis 连接见 myredis.ConfigFromEnv
+var DefaultConfig = Config{
+	Backend: BackendRedis,
+	Path:    "release-state.db",
+}
+
+// ConfigFromEnv 从环境变量 STATE_STORE、STATE_STORE_PATH 读取存储配置，没有设置的项使用 DefaultConfig
+func ConfigFromEnv() Config {
+	config := DefaultConfig
+	if v := os.Getenv("STATE_STORE"); v != "" {
+		config.Backend = v
+	}
+	if v := os.Getenv("STATE_STORE_PATH"); v != "" {
+		config.Path = v
+	}
+	return config
+}
+
+// Open 按配置打开存储
+func Open(config Config) (StateStore, error) {
+	switch config.Backend {
+	case "", BackendRedis:
+		return NewRedis(), nil
+	case BackendBolt:
+		return OpenBolt(config.Path)
+	case BackendMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("不支持的存储后端: %s", config.Backend)
+	}
+}
+
+var (
+	defaultMu    sync.Mutex
+	defaultStore StateStore
+)
+
+// Init 按配置打开默认存储，替换之前的默认存储
+func Init(config Config) error {
+	s, err := Open(config)
+	if err != nil {
+		return err
+	}
+
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultStore != nil {
+		defaultStore.Close()
+	}
+	defaultStore = s
+	return nil
+}
+
+// Default 返回默认存储，没有调用过 Init 时使用 Redis
+func Default() StateStore {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultStore == nil {
+		defaultStore = NewRedis()
+	}
+	return defaultStore
+}
+
+// Close 关闭默认存储
+func Close() error {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultStore == nil {
+		return nil
+	}
+	err := defaultStore.Close()
+	defaultStore = nil
+	return err
+}
@@ -0,0 +1,133 @@
+package wecom
+
+import (
+	"strconv"
+	"testapi/approval"
+	"time"
+)
+
+// text 企业微信多语言文本
+type text struct {
+	Text string `json:"text"`
+	Lang string `json:"lang"`
+}
+
+// control 审批申请数据中的一个控件
+type control struct {
+	Control string `json:"control"`
+	ID      string `json:"id"`
+	Title   []text `json:"title"`
+	Value   struct {
+		Text      string `json:"text"`
+		NewNumber string `json:"new_number"`
+		NewMoney  string `json:"new_money"`
+		Date      struct {
+			Type       string `json:"type"`
+			STimestamp string `json:"s_timestamp"`
+		} `json:"date"`
+		Selector struct {
+			Type    string `json:"type"`
+			Options []struct {
+				Key   string `json:"key"`
+				Value []text `json:"value"`
+			} `json:"options"`
+		} `json:"selector"`
+		Members []struct {
+			UserID string `json:"userid"`
+			Name   string `json:"name"`
+		} `json:"members"`
+		Children []struct {
+			List []control `json:"list"`
+		} `json:"children"`
+	} `json:"value"`
+}
+
+// parseContents 把控件列表转换为统一的表单
+func parseContents(contents []control) approval.Form {
+	form := make(approval.Form, 0, len(contents))
+	for _, c := range contents {
+		form = append(form, c.field())
+	}
+	return form
+}
+
+// field 按控件类型转换控件值
+func (c control) field() approval.Field {
+	field := approval.Field{ID: c.ID, Name: firstText(c.Title)}
+
+	switch c.Control {
+	case "Text", "Textarea":
+		field.Type = approval.FieldText
+		field.Value = c.Value.Text
+	case "Number":
+		field.Type = approval.FieldNumber
+		field.Value = c.Value.NewNumber
+	case "Money":
+		field.Type = approval.FieldNumber
+		field.Value = c.Value.NewMoney
+	case "Date":
+		field.Type = approval.FieldDate
+		field.Start = parseTimestamp(c.Value.Date.STimestamp)
+		field.Value = formatDate(c.Value.Date.Type, field.Start)
+	case "Selector":
+		for _, option := range c.Value.Selector.Options {
+			field.Values = append(field.Values, firstText(option.Value))
+		}
+		// 单选和多选是同一种控件，通过 selector.type 区分
+		if c.Value.Selector.Type == "multi" {
+			field.Type = approval.FieldMultiSelect
+		} else {
+			field.Type = approval.FieldSelect
+			if len(field.Values) > 0 {
+				field.Value = field.Values[0]
+			}
+		}
+	case "Contact":
+		field.Type = approval.FieldContact
+		for _, member := range c.Value.Members {
+			field.Values = append(field.Values, member.UserID)
+		}
+	case "Table":
+		field.Type = approval.FieldTable
+		for _, child := range c.Value.Children {
+			field.Rows = append(field.Rows, parseContents(child.List))
+		}
+	default:
+		field.Type = approval.FieldUnknown
+		field.Value = c.Value.Text
+	}
+	return field
+}
+
+// parseTimestamp 日期控件的值是秒级时间戳字符串，无法解析时返回零值
+func parseTimestamp(timestamp string) time.Time {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || seconds == 0 {
+		return time.Time{}
+	}
+	return time.Unix(seconds, 0)
+}
+
+// formatDate type 为 day 时只保留日期
+func formatDate(dateType string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	if dateType == "day" {
+		return t.Format("2006-01-02")
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+// firstText 取多语言文本的第一个，优先中文
+func firstText(texts []text) string {
+	for _, t := range texts {
+		if t.Lang == "zh_CN" {
+			return t.Text
+		}
+	}
+	if len(texts) > 0 {
+		return texts[0].Text
+	}
+	return ""
+}
@@ -0,0 +1,284 @@
+package wecom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testapi/approval"
+	"testapi/metrics"
+	"time"
+)
+
+// 企业微信的 corpid、审批应用的 Secret 和审批模板 id，templateID 为空时不启用企业微信审批
+const (
+	corpID     = ""
+	corpSecret = ""
+	templateID = ""
+)
+
+// 企业微信服务端 API 地址
+const apiBase = "https://qyapi.weixin.qq.com"
+
+// access_token 无效或过期的错误码，遇到时刷新 token 后重试一次
+const (
+	errInvalidToken = 40014
+	errExpiredToken = 42001
+)
+
+// Configured 是否配置了企业微信审批
+func Configured() bool {
+	return corpID != "" && corpSecret != "" && templateID != ""
+}
+
+// Source 企业微信审批来源
+type Source struct {
+	CorpID     string
+	CorpSecret string
+	TemplateID string
+
+	mu          sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+var _ approval.Source = (*Source)(nil)
+
+// NewSource 使用默认配置创建企业微信审批来源
+func NewSource() *Source {
+	return &Source{
+		CorpID:     corpID,
+		CorpSecret: corpSecret,
+		TemplateID: templateID,
+	}
+}
+
+// Name 审批来源名称
+func (s *Source) Name() string {
+	return "wecom"
+}
+
+// baseResponse 企业微信接口公共的错误码，出错时同样返回 HTTP 200
+type baseResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// getAccessToken 获取企业 access_token，有效期内复用，提前 5 分钟刷新
+func (s *Source) getAccessToken(ctx context.Context, refresh bool) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !refresh && s.accessToken != "" && time.Now().Before(s.expiresAt) {
+		return s.accessToken, nil
+	}
+
+	tokenURL := fmt.Sprintf("%s/cgi-bin/gettoken?corpid=%s&corpsecret=%s", apiBase, url.QueryEscape(s.CorpID), url.QueryEscape(s.CorpSecret))
+	req, err := http.NewRequestWithContext(ctx, "GET", tokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("创建请求失败: %w", err)
+	}
+
+	var response struct {
+		baseResponse
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	err = doRequest(req, &response)
+	if err == nil && response.ErrCode != 0 {
+		err = fmt.Errorf("%d %s", response.ErrCode, response.ErrMsg)
+	}
+	metrics.TokenRefreshed(metrics.SystemWeCom, err)
+	if err != nil {
+		return "", fmt.Errorf("获取企业微信 access_token 失败: %w", err)
+	}
+
+	s.accessToken = response.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(response.ExpiresIn)*time.Second - 5*time.Minute)
+	return s.accessToken, nil
+}
+
+// post 调用企业微信接口，token 失效时刷新后重试一次
+func (s *Source) post(ctx context.Context, path string, requestBody interface{}, result interface{}) error {
+	data, err := json.Marshal(requestBody)
+	if err != nil {
+		return fmt.Errorf("序列化请求失败: %w", err)
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		token, err := s.getAccessToken(ctx, attempt > 0)
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s%s?access_token=%s", apiBase, path, token), bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("创建请求失败: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		var raw json.RawMessage
+		if err := doRequest(req, &raw); err != nil {
+			return err
+		}
+
+		var base baseResponse
+		if err := json.Unmarshal(raw, &base); err != nil {
+			return fmt.Errorf("解析响应失败: %w", err)
+		}
+		if base.ErrCode == errInvalidToken || base.ErrCode == errExpiredToken {
+			continue
+		}
+		if base.ErrCode != 0 {
+			return fmt.Errorf("请求失败: %d %s", base.ErrCode, base.ErrMsg)
+		}
+		if err := json.Unmarshal(raw, result); err != nil {
+			return fmt.Errorf("解析响应失败: %w", err)
+		}
+		return nil
+	}
+	return fmt.Errorf("请求失败: access_token 刷新后仍然无效")
+}
+
+// doRequest 发送请求并把响应解析到 result，errcode 不为 0 时计入接口错误指标，错误由调用方处理
+func doRequest(req *http.Request, result interface{}) error {
+	client := &http.Client{Transport: metrics.Transport(metrics.SystemWeCom, nil)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("发送请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("请求失败: %s, %s", resp.Status, string(body))
+	}
+	var base baseResponse
+	if json.Unmarshal(body, &base) == nil && base.ErrCode != 0 {
+		metrics.APIError(metrics.SystemWeCom, req.Method, strconv.Itoa(base.ErrCode))
+	}
+	if err := json.Unmarshal(body, result); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	return nil
+}
+
+// ListInstances 分页列出时间段内提交的审批单号
+func (s *Source) ListInstances(ctx context.Context, start, end time.Time) ([]string, error) {
+	var spNos []string
+	cursor := ""
+	for {
+		var response struct {
+			SpNoList      []string `json:"sp_no_list"`
+			NewNextCursor string   `json:"new_next_cursor"`
+		}
+		err := s.post(ctx, "/cgi-bin/oa/getapprovalinfo", map[string]interface{}{
+			"starttime":  strconv.FormatInt(start.Unix(), 10),
+			"endtime":    strconv.FormatInt(end.Unix(), 10),
+			"new_cursor": cursor,
+			"size":       100,
+			"filters": []map[string]string{
+				{"key": "template_id", "value": s.TemplateID},
+			},
+		}, &response)
+		if err != nil {
+			return nil, fmt.Errorf("查询企业微信审批单失败: %w", err)
+		}
+		spNos = append(spNos, response.SpNoList...)
+
+		if response.NewNextCursor == "" {
+			return spNos, nil
+		}
+		cursor = response.NewNextCursor
+	}
+}
+
+// approvalDetail 企业微信审批单详情中用到的字段
+type approvalDetail struct {
+	SpNo       string `json:"sp_no"`
+	SpName     string `json:"sp_name"`
+	SpStatus   int    `json:"sp_status"`
+	TemplateID string `json:"template_id"`
+	Applyer    struct {
+		UserID string `json:"userid"`
+	} `json:"applyer"`
+	SpRecord []struct {
+		SpStatus int `json:"sp_status"`
+		Details  []struct {
+			Approver struct {
+				UserID string `json:"userid"`
+			} `json:"approver"`
+			SpStatus int   `json:"sp_status"`
+			SpTime   int64 `json:"sptime"`
+		} `json:"details"`
+	} `json:"sp_record"`
+	ApplyData struct {
+		Contents []control `json:"contents"`
+	} `json:"apply_data"`
+}
+
+// GetInstance 获取审批单详情并转换为统一的审批单结构
+func (s *Source) GetInstance(ctx context.Context, code string) (*approval.Instance, error) {
+	var response struct {
+		Info approvalDetail `json:"info"`
+	}
+	if err := s.post(ctx, "/cgi-bin/oa/getapprovaldetail", map[string]string{"sp_no": code}, &response); err != nil {
+		return nil, fmt.Errorf("获取企业微信审批单失败: %w", err)
+	}
+	detail := response.Info
+
+	instance := &approval.Instance{
+		Source:       s.Name(),
+		ApprovalCode: detail.TemplateID,
+		Code:         code,
+		Status:       convertStatus(detail.SpStatus),
+		Applicant:    approval.User{UserID: detail.Applyer.UserID},
+		Form:         parseContents(detail.ApplyData.Contents),
+	}
+
+	// 企业微信没有审批结束时间，审批结束后取最后一个审批操作的时间
+	var lastTime int64
+	seen := make(map[string]bool)
+	for _, record := range detail.SpRecord {
+		for _, d := range record.Details {
+			if d.SpTime > lastTime {
+				lastTime = d.SpTime
+			}
+			// 只有已同意(sp_status 为 2)的审批节点算作审批人
+			if d.SpStatus != 2 || d.Approver.UserID == "" || seen[d.Approver.UserID] {
+				continue
+			}
+			seen[d.Approver.UserID] = true
+			instance.Approvers = append(instance.Approvers, approval.User{UserID: d.Approver.UserID})
+		}
+	}
+	if instance.Status != approval.StatusPending && lastTime > 0 {
+		instance.EndTime = time.Unix(lastTime, 0)
+	}
+	return instance, nil
+}
+
+// convertStatus 把企业微信的 sp_status 转换为统一的审批状态
+func convertStatus(spStatus int) string {
+	switch spStatus {
+	case 1:
+		return approval.StatusPending
+	case 2:
+		return approval.StatusApproved
+	case 3:
+		return approval.StatusRejected
+	case 4, 6, 7:
+		// 已撤销、通过后撤销、已删除
+		return approval.StatusCanceled
+	default:
+		return strconv.Itoa(spStatus)
+	}
+}